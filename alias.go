@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// An endpoint preset selected with --alias
+type endpointAlias struct {
+	EndpointURL  string
+	Region       string
+	UsePathStyle bool
+}
+
+// The aliases file uses the same format as the AWS config file:
+//
+//	[wasabi]
+//	endpoint_url = https://s3.wasabisys.com
+//	region = us-east-1
+//	path_style = false
+func aliasesFilePath() (string, error) {
+	if path := os.Getenv("S3SHA256SUM_ALIASES_FILE"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "s3sha256sum", "aliases"), nil
+}
+
+func loadEndpointAlias(path, name string) (endpointAlias, error) {
+	var alias endpointAlias
+	f, err := os.Open(path)
+	if err != nil {
+		return alias, err
+	}
+	defer f.Close()
+
+	found := false
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == name {
+				found = true
+			}
+			continue
+		}
+		if section != name {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return alias, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		switch k {
+		case "endpoint_url":
+			alias.EndpointURL = v
+		case "region":
+			alias.Region = v
+		case "path_style":
+			alias.UsePathStyle, err = strconv.ParseBool(v)
+			if err != nil {
+				return alias, fmt.Errorf("%s:%d: invalid path_style value %q", path, n, v)
+			}
+		default:
+			return alias, fmt.Errorf("%s:%d: unknown key %q", path, n, k)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return alias, err
+	}
+	if !found {
+		return alias, fmt.Errorf("the alias %q is not defined in %s", name, path)
+	}
+	return alias, nil
+}
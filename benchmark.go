@@ -0,0 +1,35 @@
+package main
+
+import (
+	stdsha256 "crypto/sha256"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/minio/sha256-simd"
+)
+
+// Compare the throughput of sha256-simd against the standard library
+// Recent Go versions use SHA-NI in crypto/sha256 which may make it faster on some CPUs
+func runBenchmark() {
+	buf := make([]byte, 64*MiB)
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+	const rounds = 4
+
+	benchmark := func(name string, newHash func() hash.Hash) {
+		h := newHash()
+		start := time.Now()
+		for i := 0; i < rounds; i++ {
+			h.Write(buf)
+		}
+		h.Sum(nil)
+		elapsed := time.Since(start)
+		fmt.Printf("%-18s %8.1f MiB/s\n", name, float64(rounds*len(buf))/MiB/elapsed.Seconds())
+	}
+
+	fmt.Printf("Hashing %s %d times with each implementation.\n", formatFilesize(uint64(len(buf)), binaryUnits), rounds)
+	benchmark("minio/sha256-simd", sha256.New)
+	benchmark("crypto/sha256", stdsha256.New)
+}
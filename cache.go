@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The cache file used by --checksum-cache
+// Each line has the fingerprint, the algorithm, the sum and the name, separated by tabs
+// The name is an absolute local path or an S3Uri, and is last since it is the only field that can contain spaces
+// The fingerprint of a local file is its size and modification time (<size>:<unix nanoseconds>), and of an object its ETag
+// An entry is only used if the fingerprint is unchanged, so a modified file or object is hashed again
+type checksumCache map[checksumCacheKey]checksumCacheEntry
+
+type checksumCacheKey struct {
+	Name      string
+	Algorithm string
+}
+
+type checksumCacheEntry struct {
+	Fingerprint string
+	Sum         string
+}
+
+func localFileFingerprint(fi os.FileInfo) string {
+	return fmt.Sprintf("%d:%d", fi.Size(), fi.ModTime().UnixNano())
+}
+
+// A cache file that does not exist yet is treated as empty
+func loadChecksumCache(path string) (checksumCache, error) {
+	cache := make(checksumCache)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: expected 4 fields separated by tabs", path, n)
+		}
+		cache[checksumCacheKey{Name: fields[3], Algorithm: fields[1]}] = checksumCacheEntry{Fingerprint: fields[0], Sum: fields[2]}
+	}
+	return cache, scanner.Err()
+}
+
+// Returns the cached sums if all of the algorithms are cached with the same fingerprint, otherwise nil
+func (c checksumCache) lookup(name, fingerprint string, algorithms []string) map[string]string {
+	sums := make(map[string]string)
+	for _, algorithm := range algorithms {
+		e, ok := c[checksumCacheKey{Name: name, Algorithm: algorithm}]
+		if !ok || e.Fingerprint != fingerprint {
+			return nil
+		}
+		sums[algorithm] = e.Sum
+	}
+	return sums
+}
+
+func (c checksumCache) store(name, fingerprint string, sums map[string]string) {
+	for algorithm, sum := range sums {
+		c[checksumCacheKey{Name: name, Algorithm: algorithm}] = checksumCacheEntry{Fingerprint: fingerprint, Sum: sum}
+	}
+}
+
+// The file is replaced atomically so that an interrupted run does not leave a truncated cache file
+func (c checksumCache) save(path string) error {
+	keys := make([]checksumCacheKey, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Name != keys[j].Name {
+			return keys[i].Name < keys[j].Name
+		}
+		return keys[i].Algorithm < keys[j].Algorithm
+	})
+	var sb strings.Builder
+	sb.WriteString("# s3sha256sum --checksum-cache: <fingerprint> <algorithm> <sum> <path or S3Uri>\n")
+	for _, k := range keys {
+		e := c[k]
+		fmt.Fprintf(&sb, "%s\t%s\t%s\t%s\n", e.Fingerprint, k.Algorithm, e.Sum, k.Name)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(sb.String())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
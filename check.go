@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A line of a --check file, the sum is lowercase hex
+type checkEntry struct {
+	Sum string
+	URI string
+}
+
+// Reads a --check file, which has the format that is printed by default:
+//
+//	2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  s3://mybucket/file.txt
+//	\2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  s3://mybucket/line\nbreak.txt
+//
+// Like sha256sum -c, a line that starts with a backslash has a key with \n, \r and \\ escapes, and a * before the S3Uri is allowed
+// Blank lines and lines that start with # are ignored, sumLen is the length of the hex sums of the --algorithm
+func loadCheckFile(path string, sumLen int) ([]checkEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []checkEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(line, `\`)
+		sum, uri, ok := strings.Cut(strings.TrimPrefix(line, `\`), " ")
+		uri = strings.TrimPrefix(strings.TrimSpace(uri), "*")
+		if _, err := hex.DecodeString(sum); err != nil || sum == "" || !ok || !strings.HasPrefix(uri, "s3://") {
+			return nil, fmt.Errorf("%s:%d: expected a hex sum and an S3Uri", path, n)
+		}
+		if len(sum) != sumLen {
+			return nil, fmt.Errorf("%s:%d: the sum has %d hex digits instead of %d, the sums must be of the --algorithm", path, n, len(sum), sumLen)
+		}
+		if escaped {
+			uri = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(uri)
+		}
+		entries = append(entries, checkEntry{Sum: strings.ToLower(sum), URI: uri})
+	}
+	return entries, scanner.Err()
+}
+
+// Like sha256sum -c, the line of an object with an escaped key starts with a backslash
+func formatCheckLine(uri, result string) string {
+	if escapedURI, escaped := escapeKey(uri); escaped {
+		return `\` + escapedURI + ": " + result
+	}
+	return uri + ": " + result
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCheckFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sums.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCheckFile(t *testing.T) {
+	path := writeCheckFile(t,
+		"# comment",
+		"2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824  s3://mybucket/file.txt",
+		"",
+		`\2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824 *s3://mybucket/line\nbreak.txt`,
+	)
+	entries, err := loadCheckFile(path, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []checkEntry{
+		{Sum: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", URI: "s3://mybucket/file.txt"},
+		{Sum: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", URI: "s3://mybucket/line\nbreak.txt"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("got %d entries, expected %d", len(entries), len(expected))
+	}
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Errorf("entry %d is %+v, expected %+v", i, entries[i], expected[i])
+		}
+	}
+}
+
+func TestLoadCheckFileSumLength(t *testing.T) {
+	// An md5 sum is rejected when the --algorithm is sha256, and a sha256 sum when it is md5
+	md5Path := writeCheckFile(t, "5d41402abc4b2a76b9719d911017c592  s3://mybucket/file.txt")
+	if _, err := loadCheckFile(md5Path, 64); err == nil {
+		t.Error("expected an error for a 32 digit sum with sha256")
+	}
+	sha256Path := writeCheckFile(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  s3://mybucket/file.txt")
+	if _, err := loadCheckFile(sha256Path, 32); err == nil {
+		t.Error("expected an error for a 64 digit sum with md5")
+	}
+	if _, err := loadCheckFile(md5Path, 32); err != nil {
+		t.Errorf("expected a 32 digit sum to be accepted with md5: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "strings"
+
+// The clipboard is accessed with the command line tools of the platform, see clipboardCommands
+func readClipboard() (string, error) {
+	cmd, _, err := clipboardCommands()
+	if err != nil {
+		return "", err
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func writeClipboard(s string) error {
+	_, cmd, err := clipboardCommands()
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = strings.NewReader(s)
+	return cmd.Run()
+}
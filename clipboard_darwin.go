@@ -0,0 +1,10 @@
+//go:build darwin
+
+package main
+
+import "os/exec"
+
+// Returns the commands that read from and write to the clipboard
+func clipboardCommands() (*exec.Cmd, *exec.Cmd, error) {
+	return exec.Command("pbpaste"), exec.Command("pbcopy"), nil
+}
@@ -0,0 +1,26 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// Returns the commands that read from and write to the clipboard
+// There is no standard clipboard tool, so use the first one that is installed
+func clipboardCommands() (*exec.Cmd, *exec.Cmd, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-paste"); err == nil {
+			return exec.Command("wl-paste", "--no-newline"), exec.Command("wl-copy"), nil
+		}
+	}
+	if _, err := exec.LookPath("xclip"); err == nil {
+		return exec.Command("xclip", "-selection", "clipboard", "-out"), exec.Command("xclip", "-selection", "clipboard", "-in"), nil
+	}
+	if _, err := exec.LookPath("xsel"); err == nil {
+		return exec.Command("xsel", "--clipboard", "--output"), exec.Command("xsel", "--clipboard", "--input"), nil
+	}
+	return nil, nil, errors.New("no clipboard tool was found (install wl-clipboard, xclip or xsel)")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Objects encrypted with the S3 Encryption Client (v2 and later) have the encrypted data key and the parameters in their metadata:
+//
+//	x-amz-key-v2    the data key, encrypted with KMS (base64)
+//	x-amz-iv        the nonce (base64)
+//	x-amz-cek-alg   AES/GCM/NoPadding
+//	x-amz-wrap-alg  kms+context
+//	x-amz-matdesc   the KMS encryption context (JSON)
+//	x-amz-tag-len   128
+//
+// Only this format is supported, objects encrypted by the v1 clients (AES/CBC, or AES/GCM with the kms wrap) are not
+const (
+	cseContentAlgorithm = "AES/GCM/NoPadding"
+	cseWrapAlgorithm    = "kms+context"
+	// The largest object that is decrypted when --max-memory is not used
+	cseMaxObjectSize = 4 * GiB
+)
+
+// The object is encrypted with the S3 Encryption Client if it has the encrypted data key in its metadata
+func isClientSideEncrypted(obj *s3.GetObjectOutput) bool {
+	return obj.Metadata["x-amz-key-v2"] != ""
+}
+
+// Decrypts the data key with KMS and the body with it, the body is replaced with the plaintext
+// The whole object is read into memory, since AES-GCM only authenticates the data once all of it has been read, so objects larger than maxSize are refused
+func decryptClientSide(ctx context.Context, client *kms.Client, obj *s3.GetObjectOutput, maxSize uint64) error {
+	if obj.ContentLength != nil && uint64(*obj.ContentLength) > maxSize {
+		obj.Body.Close()
+		return fmt.Errorf("the object is %s, which is larger than the %s that can be decrypted in memory (raise the limit with --max-memory)", formatFilesize(uint64(*obj.ContentLength), binaryUnits), formatFilesize(maxSize, binaryUnits))
+	}
+	if alg := obj.Metadata["x-amz-cek-alg"]; alg != cseContentAlgorithm {
+		return fmt.Errorf("the content encryption algorithm %q is not supported (only %s)", alg, cseContentAlgorithm)
+	}
+	if alg := obj.Metadata["x-amz-wrap-alg"]; alg != cseWrapAlgorithm {
+		return fmt.Errorf("the key wrapping algorithm %q is not supported (only %s)", alg, cseWrapAlgorithm)
+	}
+	if tagLen := obj.Metadata["x-amz-tag-len"]; tagLen != "" && tagLen != "128" {
+		return fmt.Errorf("the tag length %s is not supported (only 128)", tagLen)
+	}
+	encryptedKey, err := base64.StdEncoding.DecodeString(obj.Metadata["x-amz-key-v2"])
+	if err != nil {
+		return fmt.Errorf("the encrypted data key is not valid base64: %w", err)
+	}
+	iv, err := base64.StdEncoding.DecodeString(obj.Metadata["x-amz-iv"])
+	if err != nil || len(iv) == 0 {
+		return errors.New("the object does not have a valid x-amz-iv")
+	}
+	// The encryption context includes the content encryption algorithm, which the client adds when it encrypts the object
+	var encryptionContext map[string]string
+	if err := json.Unmarshal([]byte(obj.Metadata["x-amz-matdesc"]), &encryptionContext); err != nil {
+		return fmt.Errorf("the x-amz-matdesc is not a valid encryption context: %w", err)
+	}
+	out, err := client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob:    encryptedKey,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(out.Plaintext)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return err
+	}
+	// The limit is also enforced while reading, in case the Content-Length is missing
+	ciphertext, err := io.ReadAll(io.LimitReader(obj.Body, int64(min(maxSize, math.MaxInt64-1))+1))
+	obj.Body.Close()
+	if err != nil {
+		return err
+	}
+	if uint64(len(ciphertext)) > maxSize {
+		return fmt.Errorf("the object is larger than the %s that can be decrypted in memory (raise the limit with --max-memory)", formatFilesize(maxSize, binaryUnits))
+	}
+	plaintext, err := gcm.Open(ciphertext[:0], iv, ciphertext, nil)
+	if err != nil {
+		return errors.New("the object could not be decrypted, it was modified or the data key is wrong")
+	}
+	obj.Body = io.NopCloser(bytes.NewReader(plaintext))
+	obj.ContentLength = aws.Int64(int64(len(plaintext)))
+	return nil
+}
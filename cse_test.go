@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// The data key, nonce and encryption context of an object encrypted in the format of the S3 Encryption Client v2 (AES/GCM/NoPadding with kms+context)
+// The client appends the 16 byte GCM tag to the ciphertext, and adds the content encryption algorithm to the encryption context
+var (
+	cseTestKey               = bytes.Repeat([]byte{0x42}, 32)
+	cseTestIV                = []byte("0123456789ab")
+	cseTestEncryptedKey      = []byte("encrypted data key")
+	cseTestEncryptionContext = `{"kms_cmk_id":"alias/test","aws:x-amz-cek-alg":"AES/GCM/NoPadding"}`
+)
+
+// A KMS that decrypts cseTestEncryptedKey to cseTestKey when the encryption context matches
+type fakeKMSTransport struct{}
+
+func (fakeKMSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var input struct {
+		CiphertextBlob    []byte
+		EncryptionContext map[string]string
+	}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		return nil, err
+	}
+	var expectedContext map[string]string
+	json.Unmarshal([]byte(cseTestEncryptionContext), &expectedContext)
+	status, body := http.StatusOK, `{"KeyId":"alias/test","Plaintext":"`+base64.StdEncoding.EncodeToString(cseTestKey)+`"}`
+	if !bytes.Equal(input.CiphertextBlob, cseTestEncryptedKey) || !maps.Equal(input.EncryptionContext, expectedContext) {
+		status, body = http.StatusBadRequest, `{"__type":"InvalidCiphertextException","message":"wrong key or context"}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/x-amz-json-1.1"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func newFakeKMSClient() *kms.Client {
+	return kms.New(kms.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		HTTPClient:  &http.Client{Transport: fakeKMSTransport{}},
+	})
+}
+
+func newClientSideEncryptedObject(t *testing.T, plaintext []byte) *s3.GetObjectOutput {
+	t.Helper()
+	block, err := aes.NewCipher(cseTestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := gcm.Seal(nil, cseTestIV, plaintext, nil)
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(ciphertext)),
+		ContentLength: aws.Int64(int64(len(ciphertext))),
+		Metadata: map[string]string{
+			"x-amz-key-v2":   base64.StdEncoding.EncodeToString(cseTestEncryptedKey),
+			"x-amz-iv":       base64.StdEncoding.EncodeToString(cseTestIV),
+			"x-amz-cek-alg":  "AES/GCM/NoPadding",
+			"x-amz-wrap-alg": "kms+context",
+			"x-amz-matdesc":  cseTestEncryptionContext,
+			"x-amz-tag-len":  "128",
+		},
+	}
+}
+
+func TestDecryptClientSide(t *testing.T) {
+	plaintext := []byte("hello world")
+	obj := newClientSideEncryptedObject(t, plaintext)
+	if !isClientSideEncrypted(obj) {
+		t.Fatal("the object was not detected as client-side encrypted")
+	}
+	if err := decryptClientSide(context.Background(), newFakeKMSClient(), obj, 1024); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(obj.Body)
+	if !bytes.Equal(data, plaintext) || aws.ToInt64(obj.ContentLength) != int64(len(plaintext)) {
+		t.Fatalf("decrypted to %q with a Content-Length of %d", data, aws.ToInt64(obj.ContentLength))
+	}
+}
+
+func TestDecryptClientSideModified(t *testing.T) {
+	obj := newClientSideEncryptedObject(t, []byte("hello world"))
+	ciphertext, _ := io.ReadAll(obj.Body)
+	ciphertext[0] ^= 1
+	obj.Body = io.NopCloser(bytes.NewReader(ciphertext))
+	if err := decryptClientSide(context.Background(), newFakeKMSClient(), obj, 1024); err == nil {
+		t.Fatal("expected an error for a modified object")
+	}
+
+	// The encryption context is part of the KMS request, so a changed x-amz-matdesc is refused by KMS
+	obj = newClientSideEncryptedObject(t, []byte("hello world"))
+	obj.Metadata["x-amz-matdesc"] = `{"kms_cmk_id":"alias/other","aws:x-amz-cek-alg":"AES/GCM/NoPadding"}`
+	if err := decryptClientSide(context.Background(), newFakeKMSClient(), obj, 1024); err == nil {
+		t.Fatal("expected an error for a changed encryption context")
+	}
+}
+
+func TestDecryptClientSideMaxSize(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("a"), 100)
+	obj := newClientSideEncryptedObject(t, plaintext)
+	if err := decryptClientSide(context.Background(), newFakeKMSClient(), obj, 50); err == nil || !strings.Contains(err.Error(), "--max-memory") {
+		t.Fatalf("expected an error for an object larger than the limit, got: %v", err)
+	}
+
+	// The limit is also enforced when the Content-Length is missing
+	obj = newClientSideEncryptedObject(t, plaintext)
+	obj.ContentLength = nil
+	if err := decryptClientSide(context.Background(), newFakeKMSClient(), obj, 50); err == nil || !strings.Contains(err.Error(), "--max-memory") {
+		t.Fatalf("expected an error for an object larger than the limit, got: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Downloads an object with multiple ranged requests in parallel and returns the data in order
+// The first part is read from the body of the initial GetObject request while the following parts are fetched
+// At most concurrency parts are downloaded or buffered at any time, which bounds memory use to concurrency*partSize
+type parallelReader struct {
+	first io.ReadCloser
+	// The number of bytes left to read from the first body
+	firstRemaining int64
+	queue          chan chan partResult
+	sem            chan struct{}
+	ctx            context.Context
+	cancel         context.CancelFunc
+	cur            []byte
+	// Whether cur holds a slot in sem, the slot is released once cur has been read so that it counts towards the memory bound
+	holding bool
+	err     error
+}
+
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// body is the response of a GetObject request starting at start, size is the number of bytes left in the object from there
+// getRange performs a GetObject request for the given Range header value
+func newParallelReader(ctx context.Context, body io.ReadCloser, start, size, partSize int64, concurrency int, getRange func(ctx context.Context, rng string) (io.ReadCloser, error)) *parallelReader {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &parallelReader{
+		first:          body,
+		firstRemaining: min(partSize, size),
+		queue:          make(chan chan partResult, concurrency),
+		sem:            make(chan struct{}, concurrency),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	go func() {
+		defer close(r.queue)
+		for off := start + partSize; off < start+size; off += partSize {
+			select {
+			case r.sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			end := min(off+partSize, start+size)
+			ch := make(chan partResult, 1)
+			select {
+			case r.queue <- ch:
+			case <-ctx.Done():
+				return
+			}
+			go func(off, end int64) {
+				ch <- fetchRange(ctx, off, end, getRange)
+			}(off, end)
+		}
+	}()
+	return r
+}
+
+func fetchRange(ctx context.Context, off, end int64, getRange func(ctx context.Context, rng string) (io.ReadCloser, error)) partResult {
+	body, err := getRange(ctx, fmt.Sprintf("bytes=%d-%d", off, end-1))
+	if err != nil {
+		return partResult{err: err}
+	}
+	defer body.Close()
+	data := make([]byte, end-off)
+	_, err = io.ReadFull(body, data)
+	if err != nil {
+		return partResult{err: fmt.Errorf("reading bytes %d-%d: %w", off, end-1, err)}
+	}
+	return partResult{data: data}
+}
+
+func (r *parallelReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.first != nil {
+		if int64(len(p)) > r.firstRemaining {
+			p = p[:r.firstRemaining]
+		}
+		n, err := r.first.Read(p)
+		r.firstRemaining -= int64(n)
+		if r.firstRemaining == 0 {
+			// The rest of the first body is not needed, closing it discards the connection
+			r.first.Close()
+			r.first = nil
+			err = nil
+		} else if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			r.err = err
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	for len(r.cur) == 0 {
+		if r.holding {
+			<-r.sem
+			r.holding = false
+		}
+		ch, ok := <-r.queue
+		if !ok {
+			// The queue is also closed when the download is canceled, which must not look like the end of the object
+			r.err = r.ctx.Err()
+			if r.err == nil {
+				r.err = io.EOF
+			}
+			return 0, r.err
+		}
+		res := <-ch
+		r.holding = true
+		if res.err != nil {
+			r.err = res.err
+			return 0, res.err
+		}
+		r.cur = res.data
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+func (r *parallelReader) Close() error {
+	r.cancel()
+	if r.first != nil {
+		return r.first.Close()
+	}
+	return nil
+}
+
+// Continues reading the object with a new ranged request when the body ends before the end of the object or a read fails
+// Some S3 compatible APIs end ranged responses early without an error, which would otherwise look like the end of the object
+// A read error (e.g. a connection that was reset) is retried with exponential backoff, the hash continues from the same position
+type resumingReader struct {
+	body io.ReadCloser
+	ctx  context.Context
+	// The offset in the object of the next byte, and of the end of the object
+	position int64
+	end      int64
+	// The number of new requests that can be made before giving up
+	retries  int
+	attempt  int
+	getRange func(ctx context.Context, rng string) (io.ReadCloser, error)
+	// err is io.EOF if the response ended early
+	onRetry func(position int64, err error)
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.position += int64(n)
+	if err == nil || r.position >= r.end || r.ctx.Err() != nil {
+		return n, err
+	}
+	if r.retries == 0 {
+		if err == io.EOF {
+			return n, io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+	r.retries--
+	r.attempt++
+	if r.onRetry != nil {
+		r.onRetry(r.position, err)
+	}
+	r.body.Close()
+	if err != io.EOF {
+		select {
+		case <-r.ctx.Done():
+			r.body = io.NopCloser(bytes.NewReader(nil))
+			return n, r.ctx.Err()
+		case <-time.After(retryDelay(r.attempt)):
+		}
+	}
+	body, err := r.getRange(r.ctx, fmt.Sprintf("bytes=%d-%d", r.position, r.end-1))
+	if err != nil {
+		// Keep an open body so that Close does not have to check for nil
+		r.body = io.NopCloser(bytes.NewReader(nil))
+		return n, err
+	}
+	r.body = body
+	return n, nil
+}
+
+func (r *resumingReader) Close() error {
+	return r.body.Close()
+}
+
+// Guards against S3 compatible APIs that send a different number of bytes than they report (e.g. a body for an empty object)
+// A missing or negative Content-Length means that the size is unknown
+func sizeMismatch(received uint64, contentLength *int64) bool {
+	return contentLength != nil && *contentLength >= 0 && received != uint64(*contentLength)
+}
+
+// Skips the bytes of a ranged response that come before the offset that was requested, so that no byte is hashed twice
+// A response without a Content-Range is an error, since it is not known which bytes it has (e.g. a server that ignored the range)
+func skipToOffset(body io.ReadCloser, contentRange string, offset int64) (io.ReadCloser, error) {
+	if contentRange == "" {
+		body.Close()
+		return nil, fmt.Errorf("requested the bytes from byte %d, but the response has no Content-Range (the server probably ignored the range)", offset)
+	}
+	start, ok := parseContentRangeStart(contentRange)
+	if !ok {
+		body.Close()
+		return nil, fmt.Errorf("the response has an invalid Content-Range %q", contentRange)
+	}
+	if start > uint64(offset) {
+		body.Close()
+		return nil, fmt.Errorf("the response starts at byte %d instead of byte %d", start, offset)
+	}
+	if _, err := io.CopyN(io.Discard, body, offset-int64(start)); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return body, nil
+}
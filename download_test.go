@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// A synthetic object where every byte is the MiB that it is in, so that the data does not have to be kept in memory
+type patternReader struct {
+	pos, end int64
+	// Called with the number of bytes that were read
+	onRead func(n int)
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.pos >= r.end {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), r.end-r.pos))
+	for i := 0; i < n; {
+		// Fill up to the next MiB by doubling the bytes that have been filled
+		seg := p[i:min(n, i+int(1<<20-(r.pos+int64(i))%(1<<20)))]
+		seg[0] = byte((r.pos + int64(i)) >> 20)
+		for j := 1; j < len(seg); j *= 2 {
+			copy(seg[j:], seg[:j])
+		}
+		i += len(seg)
+	}
+	r.pos += int64(n)
+	r.onRead(n)
+	return n, nil
+}
+
+func (r *patternReader) Close() error {
+	return nil
+}
+
+func TestParallelReaderMemoryBound(t *testing.T) {
+	if testing.Short() {
+		t.Skip("downloads a synthetic 4 GiB object")
+	}
+	const size = 4 << 30
+	const partSize = 8 << 20
+	const maxMemory = 64 << 20
+	concurrency := int(maxMemory / partSize)
+
+	// The bytes that have been read from the ranged responses but not yet returned by the parallelReader are buffered in memory
+	// The first part is not counted, since it is read from the first body directly into the buffer of the caller
+	var mu sync.Mutex
+	var fetched, consumed, peak int64
+	var requests, peakRequests int
+	onRead := func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetched += int64(n)
+		peak = max(peak, fetched-consumed)
+	}
+	getRange := func(ctx context.Context, rng string) (io.ReadCloser, error) {
+		var start, end int64
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		requests++
+		peakRequests = max(peakRequests, requests)
+		mu.Unlock()
+		return &requestCounter{patternReader{pos: start, end: end + 1, onRead: onRead}, func() {
+			mu.Lock()
+			requests--
+			mu.Unlock()
+		}}, nil
+	}
+
+	first := &patternReader{pos: 0, end: size, onRead: func(int) {}}
+	r := newParallelReader(context.Background(), first, 0, size, partSize, concurrency, getRange)
+	defer r.Close()
+	buf := make([]byte, 1<<20+123)
+	var pos int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] != byte(pos>>20) || buf[n-1] != byte((pos+int64(n)-1)>>20) {
+				t.Fatalf("wrong data at byte %d", pos)
+			}
+			if pos >= partSize {
+				mu.Lock()
+				consumed += int64(n)
+				mu.Unlock()
+			}
+			pos += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.sem) > concurrency {
+			t.Fatalf("%d parts hold a slot, expected at most %d", len(r.sem), concurrency)
+		}
+	}
+	if pos != size {
+		t.Fatalf("read %d bytes, expected %d", pos, size)
+	}
+	if peak > maxMemory {
+		t.Fatalf("buffered up to %d bytes, more than the %d bytes of --max-memory", peak, int64(maxMemory))
+	}
+	if peakRequests > concurrency {
+		t.Fatalf("made up to %d requests at the same time, expected at most %d", peakRequests, concurrency)
+	}
+}
+
+type requestCounter struct {
+	patternReader
+	onClose func()
+}
+
+func (r *requestCounter) Close() error {
+	r.onClose()
+	return nil
+}
+
+func TestEmptyObject(t *testing.T) {
+	h, err := newHash("sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(h, &patternReader{onRead: func(int) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := fmt.Sprintf("%x", h.Sum(nil)); sum != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Fatalf("an empty object hashed to %s", sum)
+	}
+	if sizeMismatch(uint64(n), aws.Int64(0)) {
+		t.Fatal("an empty body did not match a Content-Length of 0")
+	}
+	if !sizeMismatch(5, aws.Int64(0)) {
+		t.Fatal("a body for an empty object was not detected")
+	}
+	if sizeMismatch(5, nil) || sizeMismatch(5, aws.Int64(-1)) {
+		t.Fatal("an unknown size was treated as a mismatch")
+	}
+}
+
+func TestSkipToOffset(t *testing.T) {
+	// The response has the bytes from byte 2 of the object "0123456789"
+	newBody := func() io.ReadCloser {
+		return io.NopCloser(strings.NewReader("23456789"))
+	}
+	body, err := skipToOffset(newBody(), "bytes 2-9/10", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	if string(data) != "56789" {
+		t.Fatalf("got %q after skipping to byte 5", data)
+	}
+	// A server that ignores the range sends no Content-Range, which must not be taken as starting at byte 0
+	if _, err := skipToOffset(newBody(), "", 5); err == nil {
+		t.Error("expected an error for a response without a Content-Range")
+	}
+	if _, err := skipToOffset(newBody(), "bytes 7-9/10", 5); err == nil {
+		t.Error("expected an error for a response that starts after the offset")
+	}
+	if _, err := skipToOffset(newBody(), "bytes */10", 5); err == nil {
+		t.Error("expected an error for an invalid Content-Range")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The table used by --dynamodb-table has the partition key "bucket" and the sort key "key" (both strings),
+// and the sum is stored as a hex string in the attribute "sha256"
+// Returns an empty string if the table has no sum for the object
+func getDynamoDBSum(ctx context.Context, client *dynamodb.Client, table, bucket, key string) (string, error) {
+	out, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]dynamodbTypes.AttributeValue{
+			"bucket": &dynamodbTypes.AttributeValueMemberS{Value: bucket},
+			"key":    &dynamodbTypes.AttributeValueMemberS{Value: key},
+		},
+		ProjectionExpression:     aws.String("#sha256"),
+		ExpressionAttributeNames: map[string]string{"#sha256": "sha256"},
+	})
+	if err != nil {
+		return "", err
+	}
+	if v, ok := out.Item["sha256"].(*dynamodbTypes.AttributeValueMemberS); ok {
+		return v.Value, nil
+	}
+	return "", nil
+}
+
+// The most items that BatchWriteItem accepts in one request
+const dynamoDBBatchSize = 25
+
+// Writes the sums to the --record-to-dynamodb table in batches, with the same key schema as --dynamodb-table
+// The workers of --concurrency share the recorder, mu guards the batch
+type dynamoDBRecorder struct {
+	mu         sync.Mutex
+	client     *dynamodb.Client
+	table      string
+	maxRetries int
+	pending    []dynamodbTypes.WriteRequest
+	// A batch can not have two items with the same key, the batch is written before an object is recorded again
+	keys map[[2]string]bool
+}
+
+func newDynamoDBRecorder(client *dynamodb.Client, table string, maxRetries int) *dynamoDBRecorder {
+	return &dynamoDBRecorder{
+		client:     client,
+		table:      table,
+		maxRetries: maxRetries,
+		keys:       make(map[[2]string]bool),
+	}
+}
+
+func (r *dynamoDBRecorder) record(ctx context.Context, bucket, key, versionId, sum string, size uint64, hashedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keys[[2]string{bucket, key}] {
+		if err := r.write(ctx); err != nil {
+			return err
+		}
+	}
+	item := map[string]dynamodbTypes.AttributeValue{
+		"bucket":   &dynamodbTypes.AttributeValueMemberS{Value: bucket},
+		"key":      &dynamodbTypes.AttributeValueMemberS{Value: key},
+		"sha256":   &dynamodbTypes.AttributeValueMemberS{Value: sum},
+		"size":     &dynamodbTypes.AttributeValueMemberN{Value: strconv.FormatUint(size, 10)},
+		"hashedAt": &dynamodbTypes.AttributeValueMemberS{Value: hashedAt.UTC().Format(time.RFC3339)},
+	}
+	if versionId != "" {
+		item["versionId"] = &dynamodbTypes.AttributeValueMemberS{Value: versionId}
+	}
+	r.pending = append(r.pending, dynamodbTypes.WriteRequest{PutRequest: &dynamodbTypes.PutRequest{Item: item}})
+	r.keys[[2]string{bucket, key}] = true
+	if len(r.pending) >= dynamoDBBatchSize {
+		return r.write(ctx)
+	}
+	return nil
+}
+
+func (r *dynamoDBRecorder) flush(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.write(ctx)
+}
+
+// The items that DynamoDB did not process (e.g. because the table was throttled) are written again with exponential backoff
+func (r *dynamoDBRecorder) write(ctx context.Context) error {
+	requests := r.pending
+	r.pending = nil
+	clear(r.keys)
+	for attempt := 1; len(requests) > 0; attempt++ {
+		out, err := r.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]dynamodbTypes.WriteRequest{r.table: requests},
+		})
+		if err != nil {
+			return err
+		}
+		requests = out.UnprocessedItems[r.table]
+		if len(requests) == 0 {
+			break
+		} else if attempt > r.maxRetries {
+			return fmt.Errorf("%d items were not written after %d attempts", len(requests), attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryDelay(attempt)):
+		}
+	}
+	return nil
+}
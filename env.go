@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	flag "github.com/stefansundin/go-zflag"
+)
+
+// Flags that can be given a default with an environment variable (e.g. S3SHA256SUM_REGION for --region)
+var envDefaultFlags = []string{"region", "endpoint-url", "request-payer", "expected-bucket-owner"}
+
+func envDefaultName(name string) string {
+	return "S3SHA256SUM_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// The environment variables are only used for flags that do not have a value yet
+// A flag that was specified, or that was set by --alias, takes precedence
+func applyEnvDefaults() error {
+	for _, name := range envDefaultFlags {
+		value, ok := os.LookupEnv(envDefaultName(name))
+		if !ok || value == "" {
+			continue
+		}
+		f := flag.Lookup(name)
+		if f.Changed || f.Value.String() != f.DefValue {
+			continue
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", envDefaultName(name), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/minio/sha256-simd"
+)
+
+// The full fingerprint is the sha256 of a canonical serialization of the content sum, the user metadata and the tags:
+//
+//	content:<hex sha256 of the object>\n
+//	metadata:<key>=<value>\n  (one line per metadata entry, sorted by key)
+//	tag:<key>=<value>\n       (one line per tag, sorted by key)
+//
+// Metadata keys are lowercase, as returned by S3. Keys and values are escaped with url.QueryEscape (spaces become +),
+// so they can not contain the separators.
+func fullFingerprint(contentSum string, metadata, tags map[string]string) []byte {
+	var sb strings.Builder
+	sb.WriteString("content:" + contentSum + "\n")
+	writeSorted := func(prefix string, m map[string]string) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sb.WriteString(prefix + ":" + url.QueryEscape(k) + "=" + url.QueryEscape(m[k]) + "\n")
+		}
+	}
+	writeSorted("metadata", metadata)
+	writeSorted("tag", tags)
+	sum := sha256.Sum256([]byte(sb.String()))
+	return sum[:]
+}
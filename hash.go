@@ -1,10 +1,142 @@
 package main
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"hash"
+	"hash/crc32"
+	"io"
+	"os"
 	"reflect"
+	"strings"
+
+	"github.com/minio/sha256-simd"
 )
 
+// The algorithms that --algorithm accepts, see the README for how to add one
+var algorithmRegistry = make(map[string]func() hash.Hash)
+
+// The names of the registered algorithms, in the order that they were registered (which is the order they are listed in)
+var supportedAlgorithms []string
+
+func registerAlgorithm(name string, newHash func() hash.Hash) {
+	if _, ok := algorithmRegistry[name]; ok {
+		panic(fmt.Sprintf("the algorithm %q is already registered", name))
+	}
+	algorithmRegistry[name] = newHash
+	supportedAlgorithms = append(supportedAlgorithms, name)
+}
+
+func init() {
+	registerAlgorithm("sha256", sha256.New)
+	registerAlgorithm("sha1", sha1.New)
+	registerAlgorithm("sha512", sha512.New)
+	registerAlgorithm("md5", md5.New)
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	newHash, ok := algorithmRegistry[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	return newHash(), nil
+}
+
+// Hashes a local file with each of the algorithms, the sums are hex encoded
+func hashLocalFile(path string, algorithms []string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, algorithm := range algorithms {
+		hashes[i], err = newHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		writers[i] = hashes[i]
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string)
+	for i, algorithm := range algorithms {
+		sums[algorithm] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return sums, nil
+}
+
+// Computes the sums of the parts of a local file
+func hashLocalParts(path string, sizes []int64) (*partHasher, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := newPartHasher(sizes)
+	if _, err := io.Copy(p, f); err != nil {
+		return nil, err
+	}
+	p.finish()
+	return p, nil
+}
+
+// Downloads the bytes start to end (exclusive) of the object and returns the offset of the first byte that is different in the local file
+// Returns end if the bytes are the same
+func firstDifferentByte(ctx context.Context, getRange func(ctx context.Context, rng string) (io.ReadCloser, error), path string, start, end int64) (int64, error) {
+	body, err := getRange(ctx, fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	remote, err := io.ReadAll(body)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	local := make([]byte, end-start)
+	n, err := f.ReadAt(local, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	local = local[:n]
+	for i := 0; i < len(remote) && i < len(local); i++ {
+		if remote[i] != local[i] {
+			return start + int64(i), nil
+		}
+	}
+	return start + int64(min(len(remote), len(local))), nil
+}
+
+// Counts the bytes written to it, for hashes that have no internal length that hashGetLen can read (e.g. HMAC)
+type lengthCounter struct {
+	len uint64
+}
+
+func (c *lengthCounter) Write(p []byte) (int, error) {
+	c.len += uint64(len(p))
+	return len(p), nil
+}
+
+func (c *lengthCounter) Sum(b []byte) []byte { return b }
+func (c *lengthCounter) Reset()              { c.len = 0 }
+func (c *lengthCounter) Size() int           { return 0 }
+func (c *lengthCounter) BlockSize() int      { return 1 }
+
 // Internal hash state:
 // https://github.com/golang/go/blob/go1.17/src/crypto/sha256/sha256.go#L50-L57
 
@@ -44,3 +176,107 @@ func hashUnmarshalBinary(h *hash.Hash, b []byte) error {
 	}
 	return err
 }
+
+// A hash can be resumed if its state can be marshaled, which the hashes in the standard library support
+func isResumable(algorithm string) bool {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return false
+	}
+	_, marshaler := h.(encoding.BinaryMarshaler)
+	_, unmarshaler := h.(encoding.BinaryUnmarshaler)
+	return marshaler && unmarshaler
+}
+
+// The marshaled state starts with an identifier of the algorithm (e.g. "sha\x03" for sha256), so only the hash of the right algorithm accepts it
+// Returns the algorithm of the state and a hash that continues from it
+func unmarshalHashState(state []byte) (string, hash.Hash, error) {
+	for _, algorithm := range supportedAlgorithms {
+		if !isResumable(algorithm) {
+			continue
+		}
+		h, _ := newHash(algorithm)
+		if err := hashUnmarshalBinary(&h, state); err == nil {
+			return algorithm, h, nil
+		}
+	}
+	return "", nil, errors.New("the resume state is not the state of any of the supported algorithms")
+}
+
+// The size of the marshaled sha256 state, which is what older versions printed as the resume state
+const sha256StateSize = 108
+
+// The resume state starts with this marker, the last byte is the version of the format
+const hashStateMagic = "s3s\x01"
+
+// The resume state is the marker and the marshaled hash state, followed by a CRC-32 of them to detect values that were corrupted when copied
+func encodeHashState(state []byte) string {
+	b := append([]byte(hashStateMagic), state...)
+	return base64.RawStdEncoding.EncodeToString(binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b)))
+}
+
+// Resume states without the marker (from older versions) are only accepted if they are the marshaled state of a sha256 hash
+func decodeHashState(s string) ([]byte, error) {
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(string(b), hashStateMagic) {
+		if len(b) == sha256StateSize && isSHA256State(b) {
+			return b, nil
+		}
+		return nil, errors.New("the resume state is corrupted, make sure that it was copied correctly")
+	}
+	if len(b) < len(hashStateMagic)+4 {
+		return nil, errors.New("the resume state is too short")
+	}
+	if binary.BigEndian.Uint32(b[len(b)-4:]) != crc32.ChecksumIEEE(b[:len(b)-4]) {
+		return nil, errors.New("the resume state is corrupted, make sure that it was copied correctly")
+	}
+	return b[len(hashStateMagic) : len(b)-4], nil
+}
+
+// The marshaled state of sha256 starts with "sha\x03", so a truncated or otherwise mangled value is rejected
+func isSHA256State(state []byte) bool {
+	h := sha256.New()
+	return hashUnmarshalBinary(&h, state) == nil
+}
+
+// Prints the contents of a resume state for --dump-resume-state
+func printResumeState(s string, units unitSystem) error {
+	encodedState := s
+	concat := strings.Contains(s, ":")
+	var index int
+	var start uint64
+	if concat {
+		var err error
+		index, start, encodedState, err = parseConcatResumeState(s)
+		if err != nil {
+			return err
+		}
+	}
+	state, err := decodeHashState(encodedState)
+	if err != nil {
+		return err
+	}
+	algorithm, h, err := unmarshalHashState(state)
+	if err != nil {
+		return err
+	}
+	position := hashGetLen(h)
+	fmt.Printf("Algorithm: %s\n", algorithm)
+	if len(encodedState) == base64.RawStdEncoding.EncodedLen(sha256StateSize) {
+		fmt.Println("Checksum:  none (printed by an older version)")
+	} else {
+		fmt.Println("Checksum:  CRC-32 (valid)")
+	}
+	fmt.Printf("Position:  %s\n", formatFilesize(position, units))
+	if concat {
+		if position < start {
+			return errors.New("the position is before the start of the object")
+		}
+		fmt.Printf("Object:    %d (the first object is 0), which starts at %s in the stream\n", index, formatFilesize(start, units))
+		fmt.Printf("Offset:    %s into the object\n", formatFilesize(position-start, units))
+	}
+	return nil
+}
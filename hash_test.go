@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/minio/sha256-simd"
+)
+
+func sha256State(t *testing.T, data string) []byte {
+	t.Helper()
+	h := sha256.New()
+	h.Write([]byte(data))
+	state, err := hashMarshalBinary(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestDecodeHashState(t *testing.T) {
+	state := sha256State(t, "hello world")
+	decoded, err := decodeHashState(encodeHashState(state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != string(state) {
+		t.Fatalf("decoded state does not match the encoded state")
+	}
+}
+
+func TestDecodeHashStateCorrupted(t *testing.T) {
+	b, err := base64.RawStdEncoding.DecodeString(encodeHashState(sha256State(t, "hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range b {
+		for _, mask := range []byte{0x01, 0x80, 0xff} {
+			corrupted := append([]byte(nil), b...)
+			corrupted[i] ^= mask
+			if _, err := decodeHashState(base64.RawStdEncoding.EncodeToString(corrupted)); err == nil {
+				t.Errorf("flipping byte %d with %#x was not detected", i, mask)
+			}
+		}
+	}
+}
+
+func TestDecodeHashStateLegacy(t *testing.T) {
+	state := sha256State(t, "hello world")
+	if len(state) != sha256StateSize {
+		t.Fatalf("expected the sha256 state to be %d bytes, got %d", sha256StateSize, len(state))
+	}
+	decoded, err := decodeHashState(base64.RawStdEncoding.EncodeToString(state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	algorithm, h, err := unmarshalHashState(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algorithm != "sha256" || hashGetLen(h) != 11 {
+		t.Fatalf("got algorithm %q at position %d", algorithm, hashGetLen(h))
+	}
+
+	// 108 bytes that are not a sha256 state are rejected
+	garbage := make([]byte, sha256StateSize)
+	if _, err := decodeHashState(base64.RawStdEncoding.EncodeToString(garbage)); err == nil {
+		t.Fatal("expected an error for a legacy state that is not a sha256 state")
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "os"
+
+// Runs a --pre-hash-command or --post-hash-command with the object details in environment variables
+// The values are never interpolated into the command, so they do not need to be escaped
+// The output of the command goes to stderr to keep stdout compatible with sha256sum --check
+func runHook(command string, env map[string]string) error {
+	cmd := shellCommand(command)
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
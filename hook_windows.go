@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os/exec"
+
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd.exe", "/C", command)
+}
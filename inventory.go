@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// The manifest.json of an S3 Inventory report
+// See https://docs.aws.amazon.com/AmazonS3/latest/userguide/storage-inventory-location.html
+type inventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key         string `json:"key"`
+		MD5checksum string `json:"MD5checksum"`
+	} `json:"files"`
+}
+
+// An object listed in an S3 Inventory report, the fields that are not in the report are empty
+type inventoryObject struct {
+	URI  string
+	Size string
+	ETag string
+}
+
+// Only CSV reports are supported
+func parseInventoryManifest(data []byte) (*inventoryManifest, error) {
+	var m inventoryManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if m.FileFormat != "CSV" {
+		return nil, fmt.Errorf("the report is in the %s format, only CSV is supported", m.FileFormat)
+	}
+	if m.DestinationBucket == "" || m.FileSchema == "" {
+		return nil, errors.New("the manifest is missing the destinationBucket or the fileSchema")
+	}
+	return &m, nil
+}
+
+// The destination bucket is an ARN (arn:aws:s3:::<bucket>)
+func (m *inventoryManifest) destinationBucketName() string {
+	return m.DestinationBucket[strings.LastIndex(m.DestinationBucket, ":")+1:]
+}
+
+// Reads a gzip compressed CSV data file of the report, the columns are in the order of the fileSchema
+// The keys in the report are URL encoded, and delete markers are skipped
+func readInventoryCSV(data []byte, schema string) ([]inventoryObject, error) {
+	columns := make(map[string]int)
+	for i, name := range strings.Split(schema, ",") {
+		columns[strings.TrimSpace(name)] = i
+	}
+	bucketColumn, ok1 := columns["Bucket"]
+	keyColumn, ok2 := columns["Key"]
+	if !ok1 || !ok2 {
+		return nil, errors.New("the fileSchema does not have the Bucket and Key fields")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(gz)
+	r.FieldsPerRecord = -1
+	var objects []inventoryObject
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if field(record, "IsDeleteMarker") == "true" || max(bucketColumn, keyColumn) >= len(record) {
+			continue
+		}
+		key, err := url.QueryUnescape(record[keyColumn])
+		if err != nil {
+			return nil, fmt.Errorf("the key %q is not URL encoded correctly", record[keyColumn])
+		}
+		uri := fmt.Sprintf("s3://%s/%s", record[bucketColumn], key)
+		if versionId := field(record, "VersionId"); versionId != "" {
+			uri += "?versionId=" + versionId
+		}
+		objects = append(objects, inventoryObject{
+			URI:  uri,
+			Size: field(record, "Size"),
+			ETag: field(record, "ETag"),
+		})
+	}
+	return objects, nil
+}
+
+func getObjectBytes(ctx context.Context, client *s3.Client, input *s3.GetObjectInput) ([]byte, error) {
+	obj, err := client.GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	return io.ReadAll(obj.Body)
+}
+
+// The manifest has the MD5 of each data file, so a corrupted data file is not mistaken for a shorter list of objects
+func verifyInventoryFile(data []byte, md5sum string) error {
+	sum := md5.Sum(data)
+	if md5sum != "" && !strings.EqualFold(hex.EncodeToString(sum[:]), md5sum) {
+		return fmt.Errorf("the MD5 of the data file is %x, but the manifest says %s", sum, md5sum)
+	}
+	return nil
+}
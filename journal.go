@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/minio/sha256-simd"
+)
+
+// The journal file used by --journal records the objects that have been hashed, so that a run that was stopped can be continued
+// The first line identifies the run that the journal belongs to, and each object that has been hashed is appended as it completes:
+//
+//	# s3sha256sum --journal <run id>
+//	<status> <algorithm> <sum> <S3Uri>
+//
+// The fields are separated by tabs and the S3Uri is last since it is the only field that can contain spaces
+// The status is OK, or FAILED if the object did not match a stored sum, the --compare-local file or another verification
+// The run id is derived from the S3Uris and the algorithms, a journal from a run with other arguments is not used
+// A line that was not completely written (e.g. when the computer crashed) is ignored, so the object is hashed again
+type journal struct {
+	file    *os.File
+	entries map[string]journalEntry
+}
+
+type journalEntry struct {
+	Failed bool
+	Sums   map[string]string
+}
+
+const journalHeader = "# s3sha256sum --journal "
+
+func journalRunID(args, algorithms []string) string {
+	h := sha256.New()
+	for _, algorithm := range algorithms {
+		fmt.Fprintf(h, "%s\n", algorithm)
+	}
+	h.Write([]byte{0})
+	// The order does not matter, so the objects can be hashed in another --sort order
+	sorted := append([]string{}, args...)
+	sort.Strings(sorted)
+	for _, arg := range sorted {
+		fmt.Fprintf(h, "%s\n", arg)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// A journal that does not exist yet is created
+func openJournal(path, runID string) (*journal, error) {
+	j := &journal{entries: make(map[string]journalEntry)}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		lines := strings.Split(string(data), "\n")
+		if lines[0] != journalHeader+runID {
+			return nil, fmt.Errorf("%s belongs to a run with other objects or algorithms, delete it to start a new run", path)
+		}
+		// The last element is empty if the file ends with a newline, otherwise it is an incomplete line
+		for _, line := range lines[1 : len(lines)-1] {
+			fields := strings.SplitN(line, "\t", 4)
+			if len(fields) != 4 || (fields[0] != "OK" && fields[0] != "FAILED") {
+				continue
+			}
+			e, ok := j.entries[fields[3]]
+			if !ok {
+				e = journalEntry{Sums: make(map[string]string)}
+			}
+			e.Failed = e.Failed || fields[0] == "FAILED"
+			e.Sums[fields[1]] = fields[2]
+			j.entries[fields[3]] = e
+		}
+	}
+	j.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		err = j.write(journalHeader + runID + "\n")
+	} else if !strings.HasSuffix(string(data), "\n") {
+		// Terminate the incomplete line so that the next entry starts on a new line
+		err = j.write("\n")
+	}
+	if err != nil {
+		j.file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Returns the entry if the object has been hashed with all of the algorithms
+func (j *journal) lookup(uri string, algorithms []string) (journalEntry, bool) {
+	e, ok := j.entries[uri]
+	if !ok {
+		return e, false
+	}
+	for _, algorithm := range algorithms {
+		if e.Sums[algorithm] == "" {
+			return e, false
+		}
+	}
+	return e, true
+}
+
+// The entry is synced to disk before returning, so that a completed object is not lost in a crash
+func (j *journal) record(uri string, failed bool, sums map[string]string, algorithms []string) error {
+	status := "OK"
+	if failed {
+		status = "FAILED"
+	}
+	var sb strings.Builder
+	for _, algorithm := range algorithms {
+		fmt.Fprintf(&sb, "%s\t%s\t%s\t%s\n", status, algorithm, sums[algorithm], uri)
+	}
+	return j.write(sb.String())
+}
+
+func (j *journal) write(s string) error {
+	if _, err := j.file.WriteString(s); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
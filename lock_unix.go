@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Hold an advisory lock while writing so that lines from concurrent invocations are not interleaved
+func appendLine(f *os.File, line string) error {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	_, err := f.WriteString(line + "\n")
+	return err
+}
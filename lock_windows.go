@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows has no flock, the line is written with a single append instead
+func appendLine(f *os.File, line string) error {
+	_, err := f.WriteString(line + "\n")
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/smithy-go/logging"
+)
+
+// Diagnostic and progress messages are written to stderr through this writer
+// With --log-format json it is replaced with a jsonLogWriter
+var stderr io.Writer = os.Stderr
+
+// Turns each line written to it into a JSON log entry
+// The level is derived from the "Error:" and "Warning:" prefixes used in the messages
+type jsonLogWriter struct {
+	mu     sync.Mutex
+	buf    []byte
+	logger *slog.Logger
+}
+
+func newJSONLogWriter(w io.Writer) *jsonLogWriter {
+	return &jsonLogWriter{
+		logger: slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+}
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			break
+		}
+		line := strings.TrimSpace(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+		if line == "" {
+			continue
+		}
+		level := slog.LevelInfo
+		if strings.HasPrefix(line, "Error") {
+			level = slog.LevelError
+		} else if strings.HasPrefix(line, "Warning:") {
+			level = slog.LevelWarn
+		}
+		w.logger.Log(context.Background(), level, line)
+	}
+	return len(p), nil
+}
+
+// An error for an object, logged with --error-format json
+type errorRecord struct {
+	URI       string
+	Type      string
+	Message   string
+	Retryable bool
+}
+
+// The message is the msg of the log entry, so that the records can be parsed like the other entries
+func (w *jsonLogWriter) logError(r errorRecord) {
+	w.logger.Error(r.Message, "uri", r.URI, "type", r.Type, "retryable", r.Retryable)
+}
+
+// Logs the SDK messages (including the --debug request and response dumps) as one JSON log entry each
+func (w *jsonLogWriter) Logf(classification logging.Classification, format string, v ...interface{}) {
+	level := slog.LevelDebug
+	if classification == logging.Warn {
+		level = slog.LevelWarn
+	}
+	w.logger.Log(context.Background(), level, fmt.Sprintf(format, v...), "source", "sdk")
+}
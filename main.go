@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/hex"
@@ -9,25 +11,66 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/minio/sha256-simd"
 	flag "github.com/stefansundin/go-zflag"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 const version = "0.2.1"
 
+const defaultOutputFormat = "{{.Sum}}  s3://{{.Bucket}}/{{.Key}}"
+
+// The format of the BSD tools (and of sha256sum --tag), used with --tag
+const tagOutputFormat = "{{.Algorithm}} (s3://{{.Bucket}}/{{.Key}}) = {{.Sum}}"
+
+// The size of the chunks that are compared with --diff to find the first difference
+const diffChunkSize = 1 * MiB
+
+// Downloads of at least this size are confirmed before they start, since the data transfer can cost significant money
+const confirmDownloadSize = 100 * GiB
+
+// The price per GB of data transfer out of AWS to the internet, used to estimate the cost of a download
+const transferCostPerGB = 0.09
+
+// With --interrupt-skips, a second Ctrl-C within this time stops the program
+const interruptSkipWindow = 2 * time.Second
+
+// The fields available to the --format template
+type outputLine struct {
+	Sum          string
+	Algorithm    string
+	Bucket       string
+	Key          string
+	Size         uint64
+	ETag         string
+	VersionId    string
+	LastModified string
+	Region       string
+}
+
 func init() {
 	// Do not fail if a region is not specified anywhere
 	// This is only used for the first call that looks up the bucket region
@@ -37,25 +80,146 @@ func init() {
 }
 
 func main() {
-	var paranoidInterval time.Duration
-	var profile, region, resume, endpointURL, caBundle, versionId, expectedBucketOwner, requestPayer string
-	var noVerifySsl, noSignRequest, useAccelerateEndpoint, usePathStyle, debug, verbose, versionFlag bool
+	var watchInterval, paranoidInterval, waitTimeout time.Duration
+	var concurrency, maxConnsPerHost, maxObjects, parallelBuckets, verifyRetries, maxRetries, retryMaxAttempts, maxConcurrentParts, truncate int
+	var checkFile, outputMode, fromFile0, reportHTML, restoreTier, otelEndpoint, inventory, metricsAddr, onlyChangedETag, recordToDynamoDB, dynamoDBTable, signingRegion, journalPath, profileFromARN, maxMemory, sortOrder, checksumCachePath, checksumHeader, retryMode, sample, maxBandwidth, maxBandwidthPerPart, dumpResumeState, checksumType, sinceLastRun, manifest, compareLocal, progressURL, fromByte, preHashCommand, postHashCommand, hookFailure, hmacKey, checkpointInterval, partSize, logFormat, errorFormat, aliasName, appendTo, outputDir, profile, region, resume, caBundle, versionId, expectedBucketOwner, requestPayer, sseCustomerKey, sseCustomerKeyFile, outputFormat string
+	var regionMap, profileMap map[string]string
+	var storageClasses, algorithms, retryableErrors, headers, endpointURLs []string
+	var progress, recursive, writeTag, writeMetadata, force, assumeYes, reverse, tag, raw, compact, caBundleAppend, resumeClipboard, copyResume, concat, decompress, normalizeCRLF, checksumMode, resumeQR, noSharedConfig, noVerifySsl, forceInsecure, noSignRequest, useAccelerateEndpoint, usePathStyle, verifyOnly, headChecksum, listChecksums, printPresigned, onlyMissing, headOnly, checksumOnly, verifyETagOnly, kmsDecryptCheck, clientSideDecrypt, verifyContentMD5, reconstructETag, printParts, sidecar, verifyParallelHashes, fullFingerprintFlag, strictMetadata, detectDuplicates, merkle, compareInventory, outputOnMismatchOnly, verifyObjectLock, requireLock, diff, failOnAlgorithmMismatch, objectCountMismatchCheck, deep, verifyAndRestore, waitForObject, failFast, interruptSkips, decodeKey, canonicalizeKeys, si, syslogFlag, trace, debug, verbose, quiet, versionFlag, benchmarkFlag bool
+	flag.DurationVar(&watchInterval, "watch", 0, "After hashing the objects, download and hash them again on this interval and print an alert with a timestamp when a sum changes. Stop with Ctrl-C. (e.g. \"1h\")")
 	flag.DurationVar(&paranoidInterval, "paranoid", 0, "Print status and hash state on an interval. (e.g. \"10s\")")
+	flag.StringVar(&otelEndpoint, "otel-endpoint", "", "Export OpenTelemetry traces with OTLP over HTTP to this URL. Each object gets a span with the bucket, key, size, duration and result, with child spans for the region lookup, the GetObject request and the hashing. (e.g. \"http://localhost:4318\")")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics (objects and bytes hashed, failures, throughput and objects in flight) on this address at /metrics, for monitoring long running jobs. (e.g. \":9090\")")
+	flag.BoolVar(&progress, "progress", false, "Show the progress and the throughput of the download on stderr. On a terminal the line is updated in place, otherwise a line is printed every 10 seconds.")
+	flag.StringVar(&progressURL, "progress-url", "", "POST the progress as JSON to this URL at the --paranoid interval, for monitoring long running jobs. Failed requests do not stop the hashing.")
+	flag.StringVar(&fromByte, "from-byte", "", "Hash the object from this byte offset to the end with a new hash, skipping the bytes before it. Unlike --resume, this does not continue an existing sum. (e.g. \"512\" or \"1MiB\")")
+	flag.StringVar(&checkpointInterval, "chunked-resume-interval", "", "Print status and hash state every time this many bytes have been hashed. (e.g. \"1GiB\")")
+	flag.IntVar(&concurrency, "concurrency", 1, "Hash this many objects at the same time. The output of each object is buffered and printed in the order of the S3Uris, the messages on stderr are printed right away.")
+	flag.IntVar(&maxConnsPerHost, "max-conns-per-host", 0, "Open at most this many connections to each host, shared by --max-concurrent-parts, --parallel-buckets and the other requests. Requests wait for a connection when the limit is reached. This protects S3 compatible servers that can not handle many connections. Use 0 for no limit.")
+	flag.IntVar(&maxConcurrentParts, "max-concurrent-parts", 1, "Download this many parts of the object in parallel using ranged requests. Parts are buffered in memory until they can be hashed in order.")
+	flag.StringVar(&maxBandwidth, "max-bandwidth", "", "Limit the download to this many bytes per second in total, shared by all the parts that are downloaded in parallel. (e.g. \"10MiB\")")
+	flag.StringVar(&maxBandwidthPerPart, "max-bandwidth-per-part", "", "Limit each request (each part with --max-concurrent-parts) to this many bytes per second. Can be combined with --max-bandwidth. (e.g. \"2MiB\")")
+	flag.StringVar(&maxMemory, "max-memory", "", "The maximum amount of memory used to buffer downloaded data. Lowers --max-concurrent-parts so that the buffered parts fit, and is the largest object that --client-side-decrypt decrypts. The data is otherwise hashed as it is downloaded and not buffered. (e.g. \"256MiB\")")
+	flag.StringVar(&partSize, "part-size", "16MiB", "The size of the parts downloaded with --max-concurrent-parts (at most --max-concurrent-parts parts are held in memory), and of the parts with --checksum-type COMPOSITE.")
+	flag.IntVar(&maxObjects, "max-objects", 0, "Stop after downloading this many objects, as a safety limit for large batches. Exits with status 1 if there were more objects. (0 means no limit)")
+	flag.IntVar(&maxRetries, "max-retries", 5, "The maximum number of times to retry a request that was throttled by S3 (503 SlowDown), and to continue a download that failed partway through with a ranged request for the remaining bytes.")
+	flag.IntVar(&retryMaxAttempts, "retry-max-attempts", 0, "The maximum number of attempts the SDK makes for each request, including the first one. Defaults to $AWS_MAX_ATTEMPTS or the shared config, otherwise 3.")
+	flag.StringVar(&retryMode, "retry-mode", "", "The retry mode of the SDK. adaptive also slows down the requests when they are throttled. Possible values: standard, adaptive. Defaults to $AWS_RETRY_MODE or the shared config, otherwise standard.")
 	flag.StringVar(&profile, "profile", "", "Use a specific profile from your credential file.")
-	flag.StringVar(&region, "region", "", "The region to use. Overrides config/env settings. Avoids one API call.")
+	flag.StringVar(&aliasName, "alias", "", "Use an endpoint preset from the aliases file ($S3SHA256SUM_ALIASES_FILE or s3sha256sum/aliases in the user config directory). Sets the endpoint URL, region and path style unless they are specified.")
+	flag.StringToStringVar(&profileMap, "profile-map", nil, "Map buckets to profiles to use different credentials for different buckets. (e.g. \"bucket1=prod,bucket2=backup\")")
+	flag.StringVar(&profileFromARN, "profile-from-arn", "", "A file that maps bucket name patterns to role ARNs, one \"<pattern> <role ARN>\" per line. The role of the first pattern that matches the bucket is assumed with the default credentials. Buckets in --profile-map use their profile instead. (e.g. \"prod-* arn:aws:iam::123456789012:role/audit\")")
+	flag.StringVar(&signingRegion, "signing-region", "", "Sign the S3 requests for this region, without changing the region used to address the bucket. For S3 compatible APIs where the signing region differs from the region of the bucket.")
+	flag.StringVar(&region, "region", "", "The region to use. Overrides config/env settings. Avoids one API call. Buckets in other regions are still redirected to their region.")
+	flag.StringSliceVar(&retryableErrors, "retryable-errors", nil, "Additional error codes that the SDK should retry, separated by commas. (for use with S3 compatible APIs that return non-standard error codes)")
+	flag.IntVar(&parallelBuckets, "parallel-buckets", 8, "When the objects are in several buckets, look up the regions of this many buckets concurrently before hashing starts. Use 0 to look up the region of each bucket when its first object is hashed.")
+	flag.StringToStringVar(&regionMap, "region-map", nil, "Map buckets to regions to avoid looking up the bucket region. Takes precedence over --region. (e.g. \"bucket1=us-west-2,bucket2=eu-west-1\")")
+	flag.StringArrayVar(&headers, "header", nil, "Add a custom header to every request. Can be specified multiple times. (e.g. \"X-Tenant: example\")")
 	flag.StringVar(&resume, "resume", "", "Provide a hash state to resume from a specific position.")
-	flag.StringVar(&endpointURL, "endpoint-url", "", "Override the S3 endpoint URL. (for use with S3 compatible APIs)")
-	flag.StringVar(&caBundle, "ca-bundle", "", "The CA certificate bundle to use when verifying SSL certificates.")
-	flag.StringVar(&versionId, "version-id", "", "Version ID used to reference a specific version of the S3 object.")
+	flag.StringVar(&dumpResumeState, "dump-resume-state", "", "Print what a resume state contains (the position and, for --concat, the object) and exit.")
+	flag.BoolVar(&resumeQR, "resume-qr", false, "When interrupted, also print the resume state as a QR code.")
+	flag.BoolVar(&resumeClipboard, "resume-clipboard", false, "Resume from the hash state in the clipboard instead of providing it with --resume.")
+	flag.BoolVar(&copyResume, "copy-resume", false, "When interrupted, also copy the resume state to the clipboard (use it with --resume-clipboard).")
+	flag.StringArrayVar(&endpointURLs, "endpoint-url", nil, "Override the S3 endpoint URL. (for use with S3 compatible APIs) Use unix:///path/to.sock to connect to a unix socket. Prefix with a bucket name to only use it for that bucket. (e.g. \"mybucket=http://localhost:9000\")")
+	flag.StringVar(&caBundle, "ca-bundle", "", "The CA certificate bundle to use when verifying SSL certificates. Defaults to $AWS_CA_BUNDLE.")
+	flag.BoolVar(&caBundleAppend, "ca-bundle-append", false, "Trust the certificates in the CA bundle in addition to the system certificates, instead of only the CA bundle. (e.g. for a corporate proxy)")
+	flag.StringVar(&versionId, "version-id", "", "Version ID used to reference a specific version of the S3 object. Use \"latest\" to explicitly target the current version.")
 	flag.StringVar(&expectedBucketOwner, "expected-bucket-owner", "", "The account ID of the expected bucket owner.")
 	flag.StringVar(&requestPayer, "request-payer", "", "Confirms that the requester knows that they will be charged for the requests. Possible values: requester.")
+	flag.BoolVar(&concat, "concat", false, "Hash the objects as one concatenated stream and print a single combined sum.")
+	flag.StringVar(&sseCustomerKey, "sse-customer-key", "", "The key to use for objects encrypted with SSE-C. Must be a 256-bit key encoded as base64 or hex.")
+	flag.StringVar(&sseCustomerKeyFile, "sse-customer-key-file", "", "Read the SSE-C key from this file instead, to keep it out of the process list and shell history. The file must contain the raw 256-bit key (32 bytes).")
+	flag.StringVar(&outputDir, "output-dir", "", "Also append the output lines to one file per bucket in this directory (<bucket>.sha256, or <bucket>.<algorithm> for other algorithms).")
+	flag.StringVar(&checkFile, "check", "", "Read sums and S3Uris from this file (in the format that is printed, like sha256sum -c) and print OK or FAILED for whether each object matches the sum in the file, instead of comparing with the stored sums. Blank lines and lines that start with # are ignored. The sums must be of the --algorithm. Exits with status 1 if an object does not match, can not be read or is skipped (e.g. by --storage-class).")
+	flag.StringVar(&fromFile0, "from-file0", "", "Also hash the S3Uris in this file, which are separated by NUL characters (like the output of find -print0), so that keys with newlines can be given. Use - to read them from stdin. Keys with newlines are printed with escapes like sha256sum does.")
+	flag.StringVar(&outputMode, "output", "text", "The format of the results printed to stdout. json prints one JSON object per line for each S3Uri, with the bucket, key, version id, size, sum, the stored sum it was compared with (source and expected) and whether it matched, instead of the other output. A sum that does not match a stored sum exits with status 1. Possible values: text, json.")
+	flag.StringVar(&reportHTML, "report-html", "", "Write an HTML report to this file when the objects have been hashed, with the size, sums and result of each object and a summary, to share the results with others. The file is self-contained.")
+	flag.StringVar(&appendTo, "append-to", "", "Also append the output lines to this file. The file is locked while writing so that several invocations can append to the same file.")
+	flag.BoolVar(&outputOnMismatchOnly, "output-on-mismatch-only", false, "Only append the objects that do not match a stored sum to the --append-to file, with the computed and the expected sum, as a list of objects to follow up on.")
+	flag.StringVar(&manifest, "manifest", "", "Print the sums as manifest rows instead, for use with S3 Batch Operations. The sums are not compared with stored sums. Possible values: csv (bucket,key,versionId,sha256).")
+	flag.BoolVar(&compact, "compact", false, "Do not print blank lines between and after the results, for output that is piped to other programs.")
+	flag.StringVar(&sortOrder, "sort", "", "Hash the objects in this order instead of the order they were given in. Possible values: name.")
+	flag.BoolVar(&reverse, "reverse", false, "Hash the objects in reverse order (combined with --sort, in descending order).")
+	flag.BoolVar(&tag, "tag", false, "Print the sums in the BSD format: SHA256 (s3://<bucket>/<key>) = <sum>")
+	flag.StringVar(&outputFormat, "format", defaultOutputFormat, "The output line as a Go template. Available fields: .Sum .Algorithm .Bucket .Key .Size .ETag .VersionId .LastModified .Region")
+	flag.IntVar(&truncate, "truncate", 0, "Only print the first N hex characters of the sums, for short identifiers. The sums are still compared in full.")
+	flag.StringSliceVar(&algorithms, "algorithm", []string{"sha256"}, "The hash algorithms to compute, separated by commas. Possible values: "+strings.Join(supportedAlgorithms, ", ")+". Only a single algorithm can be resumed.")
+	flag.StringVar(&hmacKey, "hmac-key", "", "Compute an HMAC with this key instead of a plain hash. Can not be resumed.")
+	flag.BoolVar(&normalizeCRLF, "normalize-crlf", false, "Convert CRLF line endings to LF before hashing, so text objects match the sum of the same text with LF line endings. The sum is not of the data stored in S3. Can not be resumed.")
+	flag.BoolVar(&raw, "raw", false, "Hash the bytes exactly as they are stored in S3, also for objects with a Content-Encoding (this is the default). Can not be combined with --decompress, --normalize-crlf or a --header that sets Accept-Encoding.")
+	flag.BoolVar(&decompress, "decompress", false, "Decompress the object with gzip and hash the decompressed data. Can not be resumed.")
+	flag.StringVar(&checksumType, "checksum-type", "FULL_OBJECT", "The type of the S3 checksum to compare with. COMPOSITE computes the checksum of each part and requires the --part-size that the object was uploaded with. Possible values: FULL_OBJECT, COMPOSITE.")
+	flag.BoolVar(&checksumMode, "checksum-mode", false, "Ask S3 to send the stored checksum of the object and verify the downloaded data against it. The SHA256 checksum is then also compared with the sum. Without it, the S3 checksum is only compared if the response has it.")
+	flag.BoolVar(&noSharedConfig, "no-shared-config", false, "Do not load the shared config and credentials files (~/.aws). Only use environment variables and instance metadata.")
 	flag.BoolVar(&noVerifySsl, "no-verify-ssl", false, "Do not verify SSL certificates.")
+	flag.BoolVar(&forceInsecure, "force-insecure", false, "Allow --no-verify-ssl to be used with remote HTTPS endpoints.")
 	flag.BoolVar(&noSignRequest, "no-sign-request", false, "Do not sign requests.")
 	flag.BoolVar(&useAccelerateEndpoint, "use-accelerate-endpoint", false, "Use S3 Transfer Acceleration.")
 	flag.BoolVar(&usePathStyle, "use-path-style", false, "Use S3 Path Style.")
+	flag.BoolVar(&verifyOnly, "verify-only", false, "Only compare the stored metadata and tag checksums against each other. Does not download the object.")
+	flag.StringVar(&sample, "sample", "", "Only hash the first and last N bytes of the object with two ranged requests, for a quick check of whether two objects are probably the same. The sum is not a checksum of the object and can only be compared with samples of the same size. (e.g. \"1MiB\")")
+	flag.BoolVar(&printPresigned, "print-presigned", false, "Only print a presigned GET URL for the object (valid for 15 minutes), to test the signing configuration with curl. Does not download the object.")
+	flag.BoolVar(&headChecksum, "head-checksum", false, "Only compare the stored sum (the 'sha256sum' metadata or tag) with the SHA256 checksum stored by S3, without downloading the object. Exits with status 1 if they do not match.")
+	flag.BoolVar(&listChecksums, "list-checksums", false, "Only print the stored sum of each object (the 'sha256sum' metadata, tag or S3 checksum, in that order) and where it was found. Does not download the object.")
+	flag.BoolVar(&headOnly, "head-only", false, "Only print the object's size, ETag, storage class, encryption, checksums and metadata. Does not download the object.")
+	flag.BoolVar(&checksumOnly, "checksum-only", false, "Only print PASS or FAIL for whether the object matches the full object SHA256 checksum stored by S3. Exits with status 1 if any object fails.")
+	flag.BoolVar(&verifyETagOnly, "verify-etag-only", false, "Only print PASS or FAIL for whether the MD5 of the object matches its ETag. Works for objects uploaded in a single part without SSE-KMS or SSE-C. Exits with status 1 if any object fails.")
+	flag.BoolVar(&clientSideDecrypt, "client-side-decrypt", false, "Decrypt the objects that were encrypted with the S3 Encryption Client (v2 or later, with a KMS key) and hash the plaintext, so that the sum matches the original data. The data key is decrypted with KMS using the credentials of the bucket. The whole object is decrypted in memory, so objects larger than --max-memory (by default 4 GiB) are refused.")
+	flag.BoolVar(&kmsDecryptCheck, "kms-decrypt-check", false, "Only print PASS or FAIL for whether objects encrypted with SSE-KMS can be downloaded and decrypted. Objects that are not encrypted with SSE-KMS are skipped. Exits with status 1 if any object fails.")
+	flag.BoolVar(&verifyContentMD5, "verify-content-md5", false, "Also compute the MD5 of the object and compare it with the ETag and the 'content-md5' metadata. Exits with status 1 if they do not agree.")
+	flag.BoolVar(&reconstructETag, "reconstruct-etag", false, "Reconstruct the ETag (and the composite SHA256 checksum) from the exact part boundaries of the object and compare them with the stored values.")
+	flag.StringVar(&checksumCachePath, "checksum-cache", "", "Cache the sums of the --compare-local file (by path, size and modification time) and of the object (by ETag) in this file, so that they are not hashed again while they are unchanged.")
+	flag.BoolVar(&diff, "diff", false, "When the object does not match the --compare-local file, print the offset of the first byte that differs. The sha256 of each 1 MiB chunk is computed while the object is downloaded, and only the first chunk that differs is downloaded again.")
+	flag.StringVar(&compareLocal, "compare-local", "", "Compare the object with this local file. Exits with status 1 if they do not match. The sums are only computed if the sizes match.")
+	flag.BoolVar(&verifyParallelHashes, "verify-parallel-hashes", false, "Compute both the full object and the composite SHA256 checksum (with parts of --part-size) and print which of them matches the S3 checksum. For objects where it is not known how the checksum was computed.")
+	flag.BoolVar(&printParts, "parts", false, "Print the byte range, SHA256 and MD5 of each part of the object, to find the part that differs. Implies --reconstruct-etag.")
+	flag.BoolVar(&sidecar, "sidecar", false, "Compare against the sum in a sidecar object next to the object (<key>.sha256). Falls back to the metadata and tag if there is no sidecar object.")
+	flag.BoolVar(&fullFingerprintFlag, "full-fingerprint", false, "Also print a sha256 fingerprint of the object content together with its user metadata and tags, to detect changes to any of them. See the README for the canonical form.")
+	flag.StringVar(&dynamoDBTable, "dynamodb-table", "", "Also compare against the sum in this DynamoDB table, for objects whose sums are kept in an external registry. The table must have the partition key \"bucket\" and the sort key \"key\", with the sum as hex in the attribute \"sha256\". The table is in the --region or the default region.")
+	flag.StringVar(&recordToDynamoDB, "record-to-dynamodb", "", "Write the sha256 sum, size, version and time of each hashed object to this DynamoDB table, to keep a registry of the sums without modifying the objects. The table has the same keys as with --dynamodb-table. The items are written in batches of 25.")
+	flag.StringVar(&checksumHeader, "checksum-header", "", "Also compare against the sum in this response header, for uploads that do not use the 'sha256sum' metadata. The sum can be the value of the header or a sha256 parameter (e.g. \"Content-Disposition: attachment; sha256=<sum>\"), encoded as hex or base64.")
+	flag.BoolVar(&verifyObjectLock, "verify-object-lock-compliance", false, "Also print whether the object is protected by an Object Lock retention period or legal hold. Requires the s3:GetObjectRetention and s3:GetObjectLegalHold permissions.")
+	flag.BoolVar(&requireLock, "require-lock", false, "Exit with status 1 if an object is not protected by an Object Lock retention period or legal hold. Implies --verify-object-lock-compliance.")
+	flag.BoolVar(&detectDuplicates, "detect-duplicates", false, "Print the groups of objects that have the same sha256 sum (the same content under different keys) after all objects have been hashed.")
+	flag.BoolVar(&merkle, "merkle", false, "Print the root of a Merkle tree of the sha256 sums of the objects (sorted by S3Uri) after all objects have been hashed. Use --verbose to also print the nodes of the tree. See the README for how the tree is built.")
+	flag.IntVar(&verifyRetries, "verify-retries", 0, "Download and hash an object again up to this many times when it does not match a stored sum, in case the data was corrupted in transit. Exits with status 1 if it still does not match.")
+	flag.BoolVar(&strictMetadata, "strict-metadata", false, "Exit with status 1 if an object has no stored sum (metadata, tag or S3 checksum) to compare against.")
+	flag.BoolVar(&failOnAlgorithmMismatch, "fail-on-checksum-algorithm-mismatch", false, "Exit with status 1 if an object has an S3 checksum of another algorithm (e.g. CRC32C) instead of SHA256.")
+	flag.BoolVar(&waitForObject, "wait-for-object", false, "Wait for the object to exist before hashing it, for objects that are produced asynchronously. The object is polled with HeadObject.")
+	flag.BoolVar(&recursive, "recursive", false, "Hash all the objects under the S3Uris that end with a slash (s3://<bucketname>/<prefix>/) or have no key (s3://<bucketname>), using a paginated listing. Zero byte folder markers are skipped. The other S3Uris are hashed as usual.")
+	flag.BoolVar(&objectCountMismatchCheck, "object-count-mismatch-check", false, "Compare the objects under two prefixes (s3://<bucketname>/<prefix>) instead of hashing objects, e.g. after a replication or a migration. Prints the keys that are only under one of the prefixes and the keys whose sizes differ. Exits with status 1 if there are differences.")
+	flag.BoolVar(&deep, "deep", false, "With --object-count-mismatch-check, also hash the objects that are under both prefixes with the same size, and print the keys whose contents differ.")
+	flag.BoolVar(&verifyAndRestore, "verify-and-restore", false, "Restore the objects that are in GLACIER, DEEP_ARCHIVE or an archive tier of INTELLIGENT_TIERING, and hash them once they have been restored. A restore that is already in progress is waited for. The restored copies are kept for 1 day.")
+	flag.StringVar(&restoreTier, "restore-tier", "Standard", "The retrieval tier to restore the objects with when using --verify-and-restore. (Expedited, Standard or Bulk)")
+	flag.DurationVar(&waitTimeout, "wait-timeout", 10*time.Minute, "The maximum time to wait for each object with --wait-for-object, or for each restore with --verify-and-restore. Use 0 to wait indefinitely.")
+	flag.StringVar(&journalPath, "journal", "", "Record each object that has been hashed in this journal file, so that a run that was stopped can be run again with the same arguments and continue where it left off. The journal is deleted when all of the objects have been hashed. See the README for the format.")
+	flag.StringVar(&inventory, "inventory", "", "Also hash every object listed in this S3 Inventory report, given as the S3Uri or local path of its manifest.json. Only CSV reports are supported. Delete markers are skipped.")
+	flag.BoolVar(&compareInventory, "compare-inventory-checksums", false, "Compare each object with the ETag and size in the --inventory report, to find the objects that changed since the report was made. The ETags that are an MD5 of the object are also compared with the MD5 computed while hashing. Exits with status 1 if an object does not match.")
+	flag.StringVar(&onlyChangedETag, "only-changed-etag", "", "Skip the objects whose ETag is the same as in this manifest, which has an ETag and an S3Uri on each line (e.g. the output of --format '{{.ETag}}  s3://{{.Bucket}}/{{.Key}}'). Only objects that were changed are downloaded.")
+	flag.StringVar(&sinceLastRun, "since-last-run", "", "Record the ETag of each hashed object in this state file, and skip objects whose ETag has not changed since they were last hashed.")
+	flag.StringSliceVar(&storageClasses, "storage-class", nil, "Only hash objects in these storage classes, separated by commas. Other objects are skipped, e.g. to avoid errors for objects in GLACIER or DEEP_ARCHIVE. (e.g. \"STANDARD,STANDARD_IA\")")
+	flag.BoolVar(&onlyMissing, "only-missing", false, "Skip objects that already have a 'sha256sum' metadata or tag.")
+	flag.BoolVar(&writeTag, "write-tag", false, "Store the sums in the tags named after the algorithms (e.g. 'sha256sum') of the object after it has been hashed. The other tags are kept. Nothing is written if the object does not match a stored sum, unless --force is used.")
+	flag.BoolVar(&writeMetadata, "write-metadata", false, "Store the sums in the metadata named after the algorithms (e.g. 'sha256sum') of the object after it has been hashed, by copying the object onto itself. The copy keeps the headers, storage class, encryption and tags but not the ACL, and is a new version in a versioned bucket. Only objects of up to 5 GiB can be copied. Nothing is written if the object does not match a stored sum, unless --force is used.")
+	flag.BoolVar(&force, "force", false, "Let --write-tag and --write-metadata overwrite a stored sum that does not match the object.")
+	flag.StringVar(&logFormat, "log-format", "text", "The format of diagnostic messages printed to stderr. Possible values: text, json.")
+	flag.StringVar(&errorFormat, "error-format", "text", "The format of errors printed to stderr. json prints a record with the uri, error type, message and whether it is retryable for each object that fails, and implies --log-format json. Possible values: text, json.")
+	flag.BoolVar(&failFast, "fail-fast", false, "Stop at the first object that can not be hashed. By default the remaining objects are still hashed and the exit status is 1 at the end.")
+	flag.BoolVar(&interruptSkips, "interrupt-skips", false, "With multiple objects, Ctrl-C skips the object that is being hashed. Press Ctrl-C again within 2 seconds to stop.")
+	flag.StringVar(&preHashCommand, "pre-hash-command", "", "Run a shell command before hashing each object. The object is passed in the environment variables S3SHA256_URI and S3SHA256_VERSION_ID.")
+	flag.StringVar(&postHashCommand, "post-hash-command", "", "Run a shell command after hashing each object. The sum is passed in S3SHA256_SUM (and S3SHA256_ALGORITHM), in addition to S3SHA256_URI, S3SHA256_VERSION_ID and S3SHA256_SIZE.")
+	flag.StringVar(&hookFailure, "hook-failure", "warn", "What to do when a --pre-hash-command or --post-hash-command fails. Possible values: warn, abort.")
+	flag.BoolVar(&canonicalizeKeys, "canonicalize-key", false, "Decode the percent-encoded characters in the key (e.g. \"my%20file.txt\") and keep + as it is, and print a warning when the key is changed. Unlike --decode-key, a % that is not followed by two hex digits is kept. See the README for the normalization.")
+	flag.BoolVar(&decodeKey, "decode-key", false, "Treat the key as URL encoded (e.g. \"my%20file.txt\"), for keys copied from logs or URLs.")
+	flag.BoolVar(&si, "si", false, "Print sizes in decimal units (kB, MB, GB) like the AWS console instead of binary units (kiB, MiB, GiB).")
+	flag.BoolVar(&syslogFlag, "syslog", false, "Also send the sums, warnings and errors to the system log. Not supported on Windows.")
+	flag.BoolVar(&trace, "trace", false, "Print the request IDs (x-amz-request-id and x-amz-id-2) of every response to stderr, which AWS support needs to look into a request.")
+	flag.BoolVar(&assumeYes, "assume-yes", false, "Do not ask for confirmation before requester pays downloads and downloads of 100 GiB or more. Without a terminal to ask on, these downloads are refused unless this is used.", flag.OptShorthand('y'))
 	flag.BoolVar(&debug, "debug", false, "Turn on debug logging.")
 	flag.BoolVar(&verbose, "verbose", false, "Verbose output.")
+	flag.BoolVar(&quiet, "quiet", false, "Suppress warnings.")
 	flag.BoolVar(&versionFlag, "version", false, "Print version number.")
+	flag.BoolVar(&benchmarkFlag, "benchmark", false, "Compare the throughput of the sha256 implementations on this CPU.", flag.OptHidden())
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "s3sha256sum version %s\n", version)
 		fmt.Fprintln(os.Stderr, "Copyright (C) 2022 Stefan Sundin")
@@ -65,334 +229,2171 @@ func main() {
 		fmt.Fprintln(os.Stderr, "This is free software, and you are welcome to redistribute it under certain")
 		fmt.Fprintln(os.Stderr, "conditions. See the GNU General Public Licence version 3 for details.")
 		fmt.Fprintln(os.Stderr)
-		fmt.Fprintf(os.Stderr, "Usage: %s [parameters] <S3Uri> [S3Uri]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [hash] [parameters] <S3Uri> [S3Uri]...\n", os.Args[0])
 		fmt.Fprintln(os.Stderr, "S3Uri must have the format s3://<bucketname>/<key>.")
+		fmt.Fprintln(os.Stderr, "A version can be selected with s3://<bucketname>/<key>?versionId=<id>, which takes precedence over --version-id.")
 		fmt.Fprintln(os.Stderr)
 		fmt.Fprintln(os.Stderr, "Parameters:")
 		flag.PrintDefaults()
 	}
+	// hash is the only subcommand, and the default when no subcommand is given
+	if len(os.Args) > 1 && os.Args[1] == "hash" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	flag.Parse()
 
+	units := binaryUnits
+	if si {
+		units = decimalUnits
+	}
+
+	if errorFormat != "text" && errorFormat != "json" {
+		fmt.Fprintln(os.Stderr, "Error: --error-format must be text or json.")
+		os.Exit(1)
+	}
+	// The system log gets the same messages as stderr (in the same format), and the sum lines
+	var syslogOutput *syslogWriter
+	if syslogFlag {
+		logger, err := openSyslog()
+		if err != nil {
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "Warning: Unable to use the system log: %v\n", err)
+			}
+		} else {
+			syslogOutput = newSyslogWriter(logger)
+			stderr = io.MultiWriter(os.Stderr, syslogOutput)
+		}
+	}
+	// The error records are JSON log entries, so the other messages have to be JSON as well to be parseable
+	if logFormat == "json" || errorFormat == "json" {
+		stderr = newJSONLogWriter(stderr)
+	} else if logFormat != "text" {
+		fmt.Fprintln(os.Stderr, "Error: --log-format must be text or json.")
+		os.Exit(1)
+	}
+	// The results are printed to out, which discards them with --output json
+	var out io.Writer = os.Stdout
+
 	if versionFlag {
-		fmt.Println(version)
+		fmt.Fprintln(out, version)
+		os.Exit(0)
+	} else if benchmarkFlag {
+		runBenchmark()
+		os.Exit(0)
+	} else if dumpResumeState != "" {
+		err := printResumeState(dumpResumeState, units)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error decoding the resume state: %v\n", err)
+			os.Exit(1)
+		}
 		os.Exit(0)
-	} else if flag.NArg() == 0 {
+	} else if flag.NArg() == 0 && inventory == "" && fromFile0 == "" && checkFile == "" {
 		flag.Usage()
-		fmt.Fprintln(os.Stderr)
-		fmt.Fprintln(os.Stderr, "Error: At least one S3Uri parameter is required!")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Error: At least one S3Uri parameter is required!")
 		os.Exit(1)
 	}
 
-	if endpointURL != "" {
-		if !strings.HasPrefix(endpointURL, "http://") && !strings.HasPrefix(endpointURL, "https://") {
-			fmt.Fprintln(os.Stderr)
-			fmt.Fprintln(os.Stderr, "Error: The endpoint URL must start with http:// or https://.")
+	if resumeClipboard {
+		if resume != "" {
+			fmt.Fprintln(stderr, "Error: --resume-clipboard can not be combined with --resume.")
 			os.Exit(1)
 		}
-		if !usePathStyle {
-			u, err := url.Parse(endpointURL)
-			if err != nil {
-				fmt.Fprintln(os.Stderr)
-				fmt.Fprintln(os.Stderr, "Error: Unable to parse the endpoint URL.")
-				os.Exit(1)
-			}
-			hostname := u.Hostname()
-			if hostname == "localhost" || net.ParseIP(hostname) != nil {
-				if debug || verbose {
-					fmt.Fprintln(os.Stderr, "Detected IP address in endpoint URL. Implicitly opting in to path style.")
-				}
-				usePathStyle = true
-			}
+		var err error
+		resume, err = readClipboard()
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to read the clipboard: %v\n", err)
+			os.Exit(1)
 		}
-	}
-
-	// Validate that all positional arguments are formatted correctly
-	for _, arg := range flag.Args() {
-		bucket, key := parseS3Uri(arg)
-		if bucket == "" || key == "" {
-			fmt.Fprintln(os.Stderr, "Error: The S3Uri must have the format s3://<bucketname>/<key>")
+		if resume == "" {
+			fmt.Fprintln(stderr, "Error: The clipboard is empty.")
 			os.Exit(1)
 		}
 	}
 
-	// Decode the resume state
-	var h hash.Hash
-	var position uint64
-	if resume != "" {
-		if flag.NArg() > 1 {
-			fmt.Fprintln(os.Stderr, "You can only resume hashing a single object.")
-			os.Exit(1)
+	// The SDK reads $AWS_CA_BUNDLE itself, but the bundle is needed up front to add it to the system certificates
+	if caBundleAppend {
+		if caBundle == "" {
+			caBundle = os.Getenv("AWS_CA_BUNDLE")
 		}
-		state, err := base64.RawStdEncoding.DecodeString(resume)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error decoding the resume state: %v\n", err)
+		if caBundle == "" {
+			fmt.Fprintln(stderr, "Error: --ca-bundle-append requires --ca-bundle or $AWS_CA_BUNDLE.")
 			os.Exit(1)
 		}
-		h = sha256.New()
-		err = hashUnmarshalBinary(&h, state)
+	}
+
+	var roleMap []roleMapping
+	if profileFromARN != "" {
+		var err error
+		roleMap, err = loadRoleMap(profileFromARN)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error unmarshaling the resume state: %v\n", err)
+			fmt.Fprintf(stderr, "Error: Unable to read the --profile-from-arn file: %v\n", err)
 			os.Exit(1)
 		}
-		position = hashGetLen(h)
-		fmt.Fprintf(os.Stderr, "Resuming from position %s.\n", formatFilesize(position))
-		fmt.Fprintln(os.Stderr)
 	}
 
-	// If paranoid, start the go routine that runs in the background
-	// This feels a bit unsafe but haven't had any problems in my testing
-	var arg string
-	var obj *s3.GetObjectOutput
-	var objLength uint64
-	copying := false
-	if paranoidInterval != 0 {
-		go func() {
-			lastPosition := position
-			for {
-				time.Sleep(paranoidInterval)
-				if !copying {
-					continue
-				}
-				position := hashGetLen(h)
-				if position == 0 || position == lastPosition {
-					continue
+	// Flags that are specified take precedence over the alias
+	if aliasName != "" {
+		path, err := aliasesFilePath()
+		if err == nil {
+			var alias endpointAlias
+			alias, err = loadEndpointAlias(path, aliasName)
+			if err == nil {
+				if len(endpointURLs) == 0 && alias.EndpointURL != "" {
+					endpointURLs = []string{alias.EndpointURL}
 				}
-				lastPosition = position
-				state, err := hashMarshalBinary(h)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error marshaling the resume state: %v\n", err)
-					os.Exit(1)
+				if region == "" {
+					region = alias.Region
 				}
-				if state == nil {
-					continue
+				if alias.UsePathStyle {
+					usePathStyle = true
 				}
-				encodedState := base64.RawStdEncoding.EncodeToString(state)
-				fmt.Fprintf(os.Stderr, "To resume hashing from %s out of %s (%2.1f%%), run: %s\n", formatFilesize(position), formatFilesize(objLength), 100*float64(position)/float64(objLength), formatResumeCommand(encodedState, arg))
 			}
-		}()
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to load the alias: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	// Trap Ctrl-C signal
-	ctx, cancel := context.WithCancel(context.Background())
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, os.Interrupt)
-	go func() {
-		interrupted := false
-		for sig := range signalChannel {
-			if sig != os.Interrupt {
-				continue
-			}
-			if interrupted {
+	// Environment variables provide defaults for flags that are always the same in an environment
+	if err := applyEnvDefaults(); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// An endpoint URL can be qualified with a bucket name (bucket=url) to only use it for that bucket
+	var endpointURL string
+	bucketEndpoints := make(map[string]string)
+	for _, value := range endpointURLs {
+		bucket, bucketEndpoint, found := strings.Cut(value, "=")
+		if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "unix://") || !found {
+			if endpointURL != "" {
+				fmt.Fprintln(stderr, "Error: Only one --endpoint-url can be specified without a bucket qualifier.")
 				os.Exit(1)
 			}
-			fmt.Fprintln(os.Stderr, "\nInterrupt received.")
-			interrupted = true
-			cancel()
+			endpointURL = value
+		} else {
+			bucketEndpoints[bucket] = bucketEndpoint
 		}
-	}()
+	}
 
-	// Initialize the AWS SDK
-	cfg, err := config.LoadDefaultConfig(
-		ctx,
-		func(o *config.LoadOptions) error {
-			if profile != "" {
-				o.SharedConfigProfile = profile
-			}
-			if caBundle != "" {
-				f, err := os.Open(caBundle)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error opening the CA bundle: %v\n", err)
-					os.Exit(1)
-				}
-				o.CustomCABundle = f
-			}
-			if noVerifySsl {
-				o.HTTPClient = &http.Client{
-					Transport: &http.Transport{
-						TLSClientConfig: &tls.Config{
-							InsecureSkipVerify: true,
-						},
-					},
-				}
-			}
-			if debug {
-				var lm aws.ClientLogMode = aws.LogRequest | aws.LogResponse
-				o.ClientLogMode = &lm
+	// Returns true if the endpoint URL points to localhost, an IP address or a unix socket, which implies path style
+	validateEndpointURL := func(endpoint string) bool {
+		if socketPath, ok := strings.CutPrefix(endpoint, "unix://"); ok {
+			if socketPath == "" {
+				fmt.Fprintln(stderr)
+				fmt.Fprintln(stderr, "Error: The unix socket endpoint URL must have the format unix:///path/to.sock.")
+				os.Exit(1)
 			}
-			return nil
-		},
-		config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
-			o.TokenProvider = mfaTokenProvider
-		}),
-	)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error initializing the AWS SDK: %v\n", err)
-		os.Exit(1)
+			return true
+		}
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			fmt.Fprintln(stderr)
+			fmt.Fprintln(stderr, "Error: The endpoint URL must start with http://, https:// or unix://.")
+			os.Exit(1)
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			fmt.Fprintln(stderr)
+			fmt.Fprintln(stderr, "Error: Unable to parse the endpoint URL.")
+			os.Exit(1)
+		}
+		hostname := u.Hostname()
+		return hostname == "localhost" || net.ParseIP(hostname) != nil
 	}
-	client := s3.NewFromConfig(cfg,
-		func(o *s3.Options) {
-			if noSignRequest {
-				o.Credentials = aws.AnonymousCredentials{}
-			}
-			if region != "" {
-				o.Region = region
-			}
-			if endpointURL != "" {
-				o.BaseEndpoint = aws.String(endpointURL)
-			}
-			if usePathStyle {
-				o.UsePathStyle = true
+	if endpointURL != "" && validateEndpointURL(endpointURL) && !usePathStyle {
+		if debug || verbose {
+			fmt.Fprintln(stderr, "Detected IP address in endpoint URL. Implicitly opting in to path style.")
+		}
+		usePathStyle = true
+	}
+	bucketPathStyle := make(map[string]bool)
+	for bucket, bucketEndpoint := range bucketEndpoints {
+		bucketPathStyle[bucket] = usePathStyle || validateEndpointURL(bucketEndpoint)
+	}
+	// Requests to a unix socket are sent over the socket with a placeholder host name
+	setEndpoint := func(o *s3.Options, endpoint string) {
+		if socketPath, ok := strings.CutPrefix(endpoint, "unix://"); ok {
+			o.BaseEndpoint = aws.String("http://localhost")
+			o.HTTPClient = newUnixSocketHTTPClient(socketPath)
+			return
+		}
+		o.BaseEndpoint = aws.String(endpoint)
+	}
+
+	if noVerifySsl {
+		// Disabling verification is only considered safe for local endpoints (plain http:// does not use SSL at all)
+		isRemote := func(endpoint string) bool {
+			if endpoint == "" {
+				return true
 			}
-			if useAccelerateEndpoint {
-				o.UseAccelerate = true
+			u, err := url.Parse(endpoint)
+			return err != nil || (u.Scheme == "https" && !isLocalhost(u.Hostname()))
+		}
+		// The buckets of the objects in --inventory and --from-file0 are not known yet, so they use the --endpoint-url
+		remote := (inventory != "" || fromFile0 != "") && isRemote(endpointURL)
+		for _, arg := range flag.Args() {
+			bucket, _, _ := parseS3Uri(arg)
+			if bucketEndpoints[bucket] != "" {
+				remote = remote || isRemote(bucketEndpoints[bucket])
+			} else {
+				remote = remote || isRemote(endpointURL)
 			}
-		})
+		}
+		if remote && !forceInsecure {
+			fmt.Fprintln(stderr, "Error: --no-verify-ssl can only be used with a localhost endpoint unless --force-insecure is also specified.")
+			os.Exit(1)
+		}
+		if !quiet {
+			fmt.Fprintln(stderr, "Warning: SSL certificate verification is disabled. The connection can be intercepted without notice.")
+		}
+	}
 
-	// Cache bucket locations to avoid extra calls
-	bucketLocations := make(map[string]string)
+	for bucket, bucketRegion := range regionMap {
+		if bucket == "" || !isValidRegion(bucketRegion) {
+			fmt.Fprintf(stderr, "Error: Invalid --region-map entry: %s=%s\n", bucket, bucketRegion)
+			os.Exit(1)
+		}
+	}
 
-	// Loop the provided arguments
-	var i int
-	for i, arg = range flag.Args() {
-		if i != 0 {
-			fmt.Println()
+	// SSE-C requires the key (base64 encoded) and the MD5 of the key to be sent with the request
+	var sseCustomerKeyBase64, sseCustomerKeyMD5 string
+	var key []byte
+	var err error
+	if sseCustomerKey != "" {
+		key, err = decodeSSECustomerKey(sseCustomerKey)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Invalid --sse-customer-key: %v\n", err)
+			os.Exit(1)
+		}
+	} else if sseCustomerKeyFile != "" {
+		key, err = os.ReadFile(sseCustomerKeyFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to read the --sse-customer-key-file: %v\n", err)
+			os.Exit(1)
+		}
+		if len(key) != 32 {
+			fmt.Fprintf(stderr, "Error: Invalid --sse-customer-key-file: the file must contain the raw 256-bit key (32 bytes), got %d bytes\n", len(key))
+			os.Exit(1)
 		}
+	}
+	if key != nil {
+		sseCustomerKeyBase64 = base64.StdEncoding.EncodeToString(key)
+		keyMD5 := md5.Sum(key)
+		sseCustomerKeyMD5 = base64.StdEncoding.EncodeToString(keyMD5[:])
+	}
 
-		bucket, key := parseS3Uri(arg)
+	if tag {
+		outputFormat = tagOutputFormat
+	}
+	outputTemplate, err := template.New("format").Parse(outputFormat)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: Invalid --format template: %v\n", err)
+		os.Exit(1)
+	}
 
-		// Create an S3 client for the region
-		regionalClient := client
-		if endpointURL == "" && region == "" {
-			// Get the bucket location
-			if bucketLocations[bucket] == "" {
-				bucketLocationOutput, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
-					Bucket: aws.String(bucket),
-				})
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error getting bucket region: %v\n", err)
-					fmt.Fprintln(os.Stderr, "Try adding --region.")
-					os.Exit(1)
-				}
-				bucketLocations[bucket] = normalizeBucketLocation(bucketLocationOutput.LocationConstraint)
-			}
-			regionalClient = s3.NewFromConfig(cfg, func(o *s3.Options) {
-				o.Region = bucketLocations[bucket]
-				if usePathStyle {
-					o.UsePathStyle = true
-				}
-				if useAccelerateEndpoint {
-					o.UseAccelerate = true
-				}
-			})
+	customHeaders := make(http.Header)
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			fmt.Fprintf(stderr, "Error: Invalid --header value: %s\n", header)
+			fmt.Fprintln(stderr, "The header must have the format \"Name: Value\".")
+			os.Exit(1)
 		}
+		customHeaders.Add(name, strings.TrimSpace(value))
+	}
 
-		// Get the object
-		if verbose {
-			fmt.Fprintf(os.Stderr, "Getting s3://%s/%s", bucket, key)
-			if region != "" {
-				fmt.Fprintf(os.Stderr, " from %s", region)
+	// Validate that all positional arguments are formatted correctly
+	// The prefixes of --object-count-mismatch-check and --recursive can be empty to use whole buckets
+	if recursive {
+		for _, arg := range flag.Args() {
+			if _, key, objVersionId := parseS3Uri(arg); objVersionId != "" && (key == "" || strings.HasSuffix(key, "/")) {
+				fmt.Fprintf(stderr, "Error: The prefix %s can not have a versionId.\n", arg)
+				os.Exit(1)
 			}
-			fmt.Fprintln(os.Stderr)
 		}
-		input := &s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
+	}
+	for _, arg := range flag.Args() {
+		bucket, key, _ := parseS3Uri(arg)
+		if bucket == "" || (key == "" && !objectCountMismatchCheck && !recursive) {
+			fmt.Fprintln(stderr, "Error: The S3Uri must have the format s3://<bucketname>/<key>")
+			os.Exit(1)
 		}
-		if versionId != "" {
-			input.VersionId = aws.String(versionId)
+	}
+
+	// flag.Args() returns the underlying slice, so the new order is used everywhere the arguments are used (including the resume command)
+	if sortOrder == "name" {
+		sort.Strings(flag.Args())
+	} else if sortOrder != "" {
+		fmt.Fprintf(stderr, "Error: Invalid --sort %q. Possible values: name.\n", sortOrder)
+		os.Exit(1)
+	}
+	if reverse {
+		slices.Reverse(flag.Args())
+	}
+	// The objects in an --inventory report are added to the arguments once the S3 client has been created
+	args := flag.Args()
+	if fromFile0 != "" {
+		uris, err := readNullSeparated(fromFile0)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to read --from-file0: %v\n", err)
+			os.Exit(1)
 		}
-		if expectedBucketOwner != "" {
-			input.ExpectedBucketOwner = aws.String(expectedBucketOwner)
+		for _, uri := range uris {
+			if bucket, key, _ := parseS3Uri(uri); bucket == "" || key == "" {
+				fmt.Fprintf(stderr, "Error: The S3Uri %q in --from-file0 must have the format s3://<bucketname>/<key>\n", uri)
+				os.Exit(1)
+			}
 		}
-		if requestPayer != "" {
-			input.RequestPayer = s3Types.RequestPayer(requestPayer)
+		args = append(args, uris...)
+	}
+	if maxObjects < 0 {
+		fmt.Fprintln(stderr, "Error: --max-objects can not be negative.")
+		os.Exit(1)
+	}
+	if parallelBuckets < 0 {
+		fmt.Fprintln(stderr, "Error: --parallel-buckets can not be negative.")
+		os.Exit(1)
+	}
+	if maxConnsPerHost < 0 {
+		fmt.Fprintln(stderr, "Error: --max-conns-per-host can not be negative.")
+		os.Exit(1)
+	}
+	if retryMaxAttempts < 0 {
+		fmt.Fprintln(stderr, "Error: --retry-max-attempts can not be negative.")
+		os.Exit(1)
+	}
+	if retryMode != "" && retryMode != string(aws.RetryModeStandard) && retryMode != string(aws.RetryModeAdaptive) {
+		fmt.Fprintf(stderr, "Error: Invalid --retry-mode %q. Possible values: standard, adaptive.\n", retryMode)
+		os.Exit(1)
+	}
+	var sampleBytes uint64
+	if sample != "" {
+		var err error
+		sampleBytes, err = parseFilesize(sample)
+		if err != nil || sampleBytes == 0 {
+			fmt.Fprintf(stderr, "Error: Invalid --sample %q. Use a number of bytes optionally followed by a unit. (e.g. \"1MiB\")\n", sample)
+			os.Exit(1)
+		}
+	}
+	if checksumOnly {
+		// S3 stores the checksum as a sha256 of the object
+		algorithms = []string{"sha256"}
+		checksumMode = true
+	}
+	if verifyETagOnly {
+		// The ETag of a single part upload is the md5 of the object
+		algorithms = []string{"md5"}
+	}
+	if kmsDecryptCheck {
+		// The data is read to the end to make sure that all of it can be decrypted, the sum is not used
+		algorithms = []string{"sha256"}
+	}
+	for _, algorithm := range algorithms {
+		if _, err := newHash(algorithm); err != nil {
+			fmt.Fprintf(stderr, "Error: Unsupported --algorithm %q. Possible values: %s.\n", algorithm, strings.Join(supportedAlgorithms, ", "))
+			os.Exit(1)
+		}
+	}
+	if truncate < 0 {
+		fmt.Fprintln(stderr, "Error: --truncate must be a positive number of characters.")
+		os.Exit(1)
+	}
+	for _, algorithm := range algorithms {
+		if hh, _ := newHash(algorithm); truncate > 2*hh.Size() {
+			fmt.Fprintf(stderr, "Error: --truncate %d is longer than the %s sum (%d hex characters).\n", truncate, algorithm, 2*hh.Size())
+			os.Exit(1)
 		}
-		if position != 0 {
-			input.Range = aws.String(fmt.Sprintf("bytes=%d-", position))
+	}
+	var checkpointBytes uint64
+	if checkpointInterval != "" {
+		var err error
+		checkpointBytes, err = parseFilesize(checkpointInterval)
+		if err != nil || checkpointBytes == 0 {
+			fmt.Fprintf(stderr, "Error: Invalid --chunked-resume-interval %q. Use a number of bytes optionally followed by a unit. (e.g. \"1GiB\")\n", checkpointInterval)
+			os.Exit(1)
 		}
-		obj, err = regionalClient.GetObject(ctx, input)
+	}
+	var fromByteOffset uint64
+	if fromByte != "" {
+		var err error
+		fromByteOffset, err = parseFilesize(fromByte)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintf(stderr, "Error: Invalid --from-byte %q. Use a number of bytes optionally followed by a unit. (e.g. \"1MiB\")\n", fromByte)
 			os.Exit(1)
 		}
-		objLength = position + uint64(aws.ToInt64(obj.ContentLength))
+	}
+	if checksumType != "FULL_OBJECT" && checksumType != "COMPOSITE" {
+		fmt.Fprintf(stderr, "Error: Invalid --checksum-type %q. Possible values: FULL_OBJECT, COMPOSITE.\n", checksumType)
+		os.Exit(1)
+	}
+	if outputMode != "text" && outputMode != "json" {
+		fmt.Fprintln(stderr, "Error: --output must be text or json.")
+		os.Exit(1)
+	}
+	if maxConcurrentParts < 1 {
+		fmt.Fprintln(stderr, "Error: --max-concurrent-parts must be at least 1.")
+		os.Exit(1)
+	}
+	if verifyRetries < 0 {
+		fmt.Fprintln(stderr, "Error: --verify-retries can not be negative.")
+		os.Exit(1)
+	}
+	if printParts {
+		reconstructETag = true
+	}
+	if requireLock {
+		verifyObjectLock = true
+	}
 
-		// Compute the sha256 hash
-		// The body is streamed so it is computing while the object is being downloaded
-		if resume == "" {
-			h = sha256.New()
+	// The options that can not be combined and the options that require other options are checked by the rules in optionRules
+	// The rules name the options the way they are written in the errors, and look up here whether each of them is used
+	onlyPrintsOtherOutput := verifyOnly || headOnly || headChecksum || listChecksums || checksumOnly || verifyETagOnly || kmsDecryptCheck || printPresigned
+	optionsSet := map[string]bool{
+		"--profile and --profile-map":          profile != "" || len(profileMap) > 0,
+		"--no-shared-config":                   noSharedConfig,
+		"--sse-customer-key":                   sseCustomerKey != "",
+		"--sse-customer-key-file":              sseCustomerKeyFile != "",
+		"--tag":                                tag,
+		"--format":                             flag.CommandLine.Changed("format"),
+		"--raw":                                raw,
+		"a --header that sets Accept-Encoding": customHeaders.Get("Accept-Encoding") != "",
+		"--canonicalize-key":                   canonicalizeKeys,
+		"--decode-key":                         decodeKey,
+		"--object-count-mismatch-check":        objectCountMismatchCheck,
+		"--deep":                               deep,
+		"--recursive":                          recursive,
+		"--version-id":                         versionId != "",
+		"--from-file0":                         fromFile0 != "",
+		"--check":                              checkFile != "",
+		"S3Uri arguments":                      flag.NArg() > 0,
+		"--inventory":                          inventory != "",
+		"--compare-inventory-checksums":        compareInventory,
+		"--sort":                               sortOrder != "",
+		"--reverse":                            reverse,
+		"--concat":                             concat,
+		"--verify-only":                        verifyOnly,
+		"--head-only":                          headOnly,
+		"--head-checksum":                      headChecksum,
+		"--list-checksums":                     listChecksums,
+		"--checksum-only":                      checksumOnly,
+		"--checksum-mode":                      checksumMode,
+		"--verify-etag-only":                   verifyETagOnly,
+		"--kms-decrypt-check":                  kmsDecryptCheck,
+		"--print-presigned":                    printPresigned,
+		"--no-sign-request":                    noSignRequest,
+		"--only-missing":                       onlyMissing,
+		"--resume":                             resume != "",
+		"--max-objects":                        maxObjects > 0,
+		"--sample":                             sample != "",
+		"--from-byte":                          fromByte != "",
+		"--hmac-key":                           hmacKey != "",
+		"--decompress":                         decompress,
+		"--normalize-crlf":                     normalizeCRLF,
+		"--reconstruct-etag":                   reconstructETag,
+		"--parts":                              printParts,
+		"--verify-content-md5":                 verifyContentMD5,
+		"--checksum-type COMPOSITE":            checksumType == "COMPOSITE",
+		"--verify-parallel-hashes":             verifyParallelHashes,
+		"--report-html":                        reportHTML != "",
+		"--manifest":                           manifest != "",
+		"--output json":                        outputMode == "json",
+		"--watch":                              watchInterval > 0,
+		"--detect-duplicates":                  detectDuplicates,
+		"--merkle":                             merkle,
+		"--diff":                               diff,
+		"--client-side-decrypt":                clientSideDecrypt,
+		"--max-concurrent-parts":               maxConcurrentParts > 1,
+		"--compare-local":                      compareLocal != "",
+		"--pre-hash-command and --post-hash-command": preHashCommand != "" || postHashCommand != "",
+		"--strict-metadata":                          strictMetadata,
+		"--dynamodb-table":                           dynamoDBTable != "",
+		"--record-to-dynamodb":                       recordToDynamoDB != "",
+		"--checksum-header":                          checksumHeader != "",
+		"--verify-object-lock-compliance":            verifyObjectLock,
+		"--verify-retries":                           verifyRetries > 0,
+		"--fail-on-checksum-algorithm-mismatch":      failOnAlgorithmMismatch,
+		"--full-fingerprint":                         fullFingerprintFlag,
+		"--storage-class":                            len(storageClasses) > 0,
+		"--verify-and-restore":                       verifyAndRestore,
+		"--write-tag and --write-metadata":           writeTag || writeMetadata,
+		"--write-metadata":                           writeMetadata,
+		"--force":                                    force,
+		"--progress-url":                             progressURL != "",
+		"--paranoid":                                 paranoidInterval != 0,
+		"--only-changed-etag":                        onlyChangedETag != "",
+		"--since-last-run":                           sinceLastRun != "",
+		"--concurrency":                              concurrency > 1,
+		"--progress":                                 progress,
+		"--interrupt-skips":                          interruptSkips,
+		"--journal":                                  journalPath != "",
+		"--checksum-cache":                           checksumCachePath != "",
+		"--output-on-mismatch-only":                  outputOnMismatchOnly,
+		"--output-dir":                               outputDir != "",
+
+		// The groups of options that the errors name together
+		printsOtherOutput:                               onlyPrintsOtherOutput,
+		"the options that hash the whole object":        concat || verifyOnly || listChecksums || headOnly || checksumOnly || verifyETagOnly || kmsDecryptCheck || reconstructETag || verifyContentMD5 || decompress || normalizeCRLF || resume != "" || fromByte != "" || hmacKey != "",
+		"the options that do not hash the whole object": onlyPrintsOtherOutput || objectCountMismatchCheck,
+		"the options that print other output":           concat || verifyOnly || headOnly || checksumOnly || verifyETagOnly || onlyMissing || reconstructETag || verifyContentMD5 || compareLocal != "" || fullFingerprintFlag || strictMetadata,
+
+		// Whether each requirement is met
+		"requires the sha256 algorithm":                              slices.Contains(algorithms, "sha256"),
+		"can only be used with --algorithm sha256":                   len(algorithms) == 1 && algorithms[0] == "sha256",
+		"can only be used with one --algorithm":                      len(algorithms) == 1,
+		"can only be used with a single --algorithm":                 len(algorithms) == 1 && isResumable(algorithms[0]),
+		"requires exactly two S3Uri prefixes":                        flag.NArg() == 2,
+		"requires --object-count-mismatch-check":                     objectCountMismatchCheck,
+		"requires --inventory":                                       inventory != "",
+		"requires the --part-size that the object was uploaded with": flag.CommandLine.Changed("part-size"),
+		"requires --write-tag or --write-metadata":                   writeTag || writeMetadata,
+		"requires --paranoid to set the interval":                    paranoidInterval != 0,
+		"can only be used with a single object":                      len(args) <= 1,
+		"can only be used with --compare-local":                      compareLocal != "",
+		"requires --append-to":                                       appendTo != "",
+	}
+	if msg := checkOptionRules(optionRules, optionsSet); msg != "" {
+		fmt.Fprintf(stderr, "Error: %s\n", msg)
+		os.Exit(1)
+	}
+
+	// The objects in the --check file are the only objects, checkSums has the sum of each of them
+	var checkSums []string
+	if checkFile != "" {
+		h, err := newHash(algorithms[0])
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
-		copying = true
-		_, err = io.Copy(h, obj.Body)
-		copying = false
+		entries, err := loadCheckFile(checkFile, hex.EncodedLen(h.Size()))
 		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				position := hashGetLen(h)
-				fmt.Fprintf(os.Stderr, "Aborted after %s out of %s (%2.1f%%).\n", formatFilesize(position), formatFilesize(objLength), 100*float64(position)/float64(objLength))
-				fmt.Fprintln(os.Stderr)
-				state, err := hashMarshalBinary(h)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error marshaling the resume state: %v\n", err)
-					os.Exit(1)
-				}
-				encodedState := base64.RawStdEncoding.EncodeToString(state)
-				fmt.Fprintln(os.Stderr, "To resume hashing from this position, run:")
-				fmt.Fprintln(os.Stderr, formatResumeCommand(encodedState, arg))
-				fmt.Fprintln(os.Stderr)
-				fmt.Fprintln(os.Stderr, "Note: This value is the internal state of the hash function. It may not be compatible across versions of s3sha256sum or across Go versions.")
-			} else {
-				fmt.Fprintln(os.Stderr, err)
-			}
+			fmt.Fprintf(stderr, "Error: Unable to read the --check file: %v\n", err)
 			os.Exit(1)
 		}
-		if paranoidInterval != 0 || verbose {
-			fmt.Fprintln(os.Stderr)
+		if len(entries) == 0 {
+			fmt.Fprintf(stderr, "Error: There are no sums in the --check file %s.\n", checkFile)
+			os.Exit(1)
 		}
-
-		// Print the sum
-		sum := hex.EncodeToString(h.Sum(nil))
-		fmt.Printf("%s  s3://%s/%s\n", sum, bucket, key)
-		fmt.Println()
-
-		// Compare with the object metadata if possible
-		objSum := obj.Metadata["sha256sum"]
-		objSumSource := "metadata"
-		if objSum == "" && aws.ToInt32(obj.TagCount) > 0 {
-			// No metadata entry, check if there's a tag
-			getObjectTaggingInput := &s3.GetObjectTaggingInput{
-				Bucket: aws.String(bucket),
-				Key:    aws.String(key),
-			}
-			if versionId != "" {
-				getObjectTaggingInput.VersionId = aws.String(versionId)
-			}
-			if expectedBucketOwner != "" {
-				getObjectTaggingInput.ExpectedBucketOwner = aws.String(expectedBucketOwner)
-			}
-			if requestPayer != "" {
-				getObjectTaggingInput.RequestPayer = s3Types.RequestPayer(requestPayer)
+		for _, e := range entries {
+			if bucket, key, _ := parseS3Uri(e.URI); bucket == "" || key == "" {
+				fmt.Fprintf(stderr, "Error: The S3Uri %q in the --check file must have the format s3://<bucketname>/<key>\n", e.URI)
+				os.Exit(1)
 			}
-			tags, err := regionalClient.GetObjectTagging(ctx, getObjectTaggingInput)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Was not able to get object tags (looking for 'sha256sum' tag to compare against).")
-				fmt.Fprintln(os.Stderr, err)
+			args = append(args, e.URI)
+			checkSums = append(checkSums, e.Sum)
+		}
+	}
+	// The sum of each part of a composite checksum is computed with the --part-size that the object was uploaded with
+	composite := checksumType == "COMPOSITE" || verifyParallelHashes
+	var partSizeBytes uint64
+	if maxConcurrentParts > 1 || composite {
+		var err error
+		partSizeBytes, err = parseFilesize(partSize)
+		if err != nil || partSizeBytes == 0 {
+			fmt.Fprintf(stderr, "Error: Invalid --part-size %q. Use a number of bytes optionally followed by a unit. (e.g. \"16MiB\")\n", partSize)
+			os.Exit(1)
+		}
+	}
+	// Only the parts downloaded with --max-concurrent-parts and the objects decrypted with --client-side-decrypt are buffered in memory, at most one part per concurrent download
+	var maxMemoryBytes uint64
+	if maxMemory != "" {
+		var err error
+		maxMemoryBytes, err = parseFilesize(maxMemory)
+		if err != nil || maxMemoryBytes == 0 {
+			fmt.Fprintf(stderr, "Error: Invalid --max-memory %q. Use a number of bytes optionally followed by a unit. (e.g. \"256MiB\")\n", maxMemory)
+			os.Exit(1)
+		}
+		if maxConcurrentParts > 1 && uint64(maxConcurrentParts)*partSizeBytes > maxMemoryBytes {
+			if partSizeBytes > maxMemoryBytes {
+				fmt.Fprintf(stderr, "Error: A single part of --part-size %s does not fit in --max-memory %s.\n", formatFilesize(partSizeBytes, units), formatFilesize(maxMemoryBytes, units))
 				os.Exit(1)
 			}
-			for _, t := range tags.TagSet {
-				if aws.ToString(t.Key) == "sha256sum" {
-					objSum = aws.ToString(t.Value)
-					objSumSource = "tag"
-					break
-				}
+			maxConcurrentParts = int(maxMemoryBytes / partSizeBytes)
+			if !quiet {
+				fmt.Fprintf(stderr, "Warning: Lowering --max-concurrent-parts to %d to stay within --max-memory %s.\n", maxConcurrentParts, formatFilesize(maxMemoryBytes, units))
 			}
 		}
-		if objSum == "" {
-			fmt.Println("Metadata 'sha256sum' not present. Populate this metadata (or tag) to enable automatic comparison.")
-		} else if strings.EqualFold(sum, objSum) {
-			fmt.Printf("OK (matches object %s)\n", objSumSource)
-		} else {
-			fmt.Printf("FAILED (did not match object %s)\n", objSumSource)
-			fmt.Printf("Expected: %s\n", objSum)
+	}
+	// A single limiter is shared by all requests for --max-bandwidth, while every request gets its own limiter for --max-bandwidth-per-part
+	var bandwidthLimiter *rateLimiter
+	var bandwidthPerPart uint64
+	if maxBandwidth != "" {
+		n, err := parseFilesize(maxBandwidth)
+		if err != nil || n == 0 {
+			fmt.Fprintf(stderr, "Error: Invalid --max-bandwidth %q. Use a number of bytes per second optionally followed by a unit. (e.g. \"10MiB\")\n", maxBandwidth)
+			os.Exit(1)
+		}
+		bandwidthLimiter = newRateLimiter(n)
+	}
+	if maxBandwidthPerPart != "" {
+		var err error
+		bandwidthPerPart, err = parseFilesize(maxBandwidthPerPart)
+		if err != nil || bandwidthPerPart == 0 {
+			fmt.Fprintf(stderr, "Error: Invalid --max-bandwidth-per-part %q. Use a number of bytes per second optionally followed by a unit. (e.g. \"2MiB\")\n", maxBandwidthPerPart)
+			os.Exit(1)
+		}
+	}
+	// The hash state can only be resumed when it is the state of a single hash of the bytes of the whole object, which is what the --resume rule requires
+	resumable := fromByte == "" && !decompress && !normalizeCRLF && hmacKey == "" && len(algorithms) == 1 && isResumable(algorithms[0])
+	if hookFailure != "warn" && hookFailure != "abort" {
+		fmt.Fprintf(stderr, "Error: Invalid --hook-failure %q. Possible values: warn, abort.\n", hookFailure)
+		os.Exit(1)
+	}
+	if manifest != "" && manifest != "csv" {
+		fmt.Fprintf(stderr, "Error: Invalid --manifest %q. Possible values: csv.\n", manifest)
+		os.Exit(1)
+	}
+	if verifyAndRestore {
+		i := slices.IndexFunc(s3Types.Tier("").Values(), func(tier s3Types.Tier) bool {
+			return strings.EqualFold(string(tier), restoreTier)
+		})
+		if i == -1 {
+			fmt.Fprintln(stderr, "Error: --restore-tier must be Expedited, Standard or Bulk.")
+			os.Exit(1)
+		}
+		restoreTier = string(s3Types.Tier("").Values()[i])
+	}
+	for i, storageClass := range storageClasses {
+		storageClasses[i] = strings.ToUpper(strings.TrimSpace(storageClass))
+	}
+
+	if progressURL != "" && !strings.HasPrefix(progressURL, "http://") && !strings.HasPrefix(progressURL, "https://") {
+		fmt.Fprintln(stderr, "Error: The --progress-url must start with http:// or https://.")
+		os.Exit(1)
+	}
+
+	var etagManifest map[string]string
+	if onlyChangedETag != "" {
+		var err error
+		etagManifest, err = loadETagManifest(onlyChangedETag)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to read the --only-changed-etag manifest: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var lastRun runState
+	if sinceLastRun != "" {
+		var err error
+		lastRun, err = loadRunState(sinceLastRun)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to read the --since-last-run state file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if concurrency < 1 {
+		fmt.Fprintln(stderr, "Error: --concurrency must be at least 1.")
+		os.Exit(1)
+	}
+
+	var runJournal *journal
+	if journalPath != "" {
+		var err error
+		runArgs := args
+		if inventory != "" {
+			runArgs = append(slices.Clone(runArgs), "--inventory="+inventory)
+		}
+		runJournal, err = openJournal(journalPath, journalRunID(runArgs, algorithms))
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to open the --journal file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var localSize int64
+	var localPath, localFingerprint string
+	if compareLocal != "" {
+		fi, err := os.Stat(compareLocal)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to read the --compare-local file: %v\n", err)
+			os.Exit(1)
+		}
+		if !fi.Mode().IsRegular() {
+			fmt.Fprintf(stderr, "Error: The --compare-local path %s is not a file.\n", compareLocal)
+			os.Exit(1)
+		}
+		localSize = fi.Size()
+		localFingerprint = localFileFingerprint(fi)
+		localPath, err = filepath.Abs(compareLocal)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to read the --compare-local file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var cache checksumCache
+	if checksumCachePath != "" {
+		var err error
+		cache, err = loadChecksumCache(checksumCachePath)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to read the --checksum-cache file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	saveCache := func() {
+		if err := cache.save(checksumCachePath); err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to write the --checksum-cache file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var appendFile *os.File
+	if watchInterval < 0 {
+		fmt.Fprintln(stderr, "Error: --watch can not be negative.")
+		os.Exit(1)
+	}
+	if appendTo != "" {
+		var err error
+		appendFile, err = os.OpenFile(appendTo, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to open the --append-to file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// The checksum files are opened when the first sum for the bucket is printed, by any of the workers
+	outputFiles := make(map[string]*os.File)
+	var outputFilesMu sync.Mutex
+	if outputDir != "" {
+		err := os.MkdirAll(outputDir, 0755)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to create the --output-dir directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	outputFile := func(bucket, algorithm string) (*os.File, error) {
+		name := bucket + "." + algorithm
+		outputFilesMu.Lock()
+		defer outputFilesMu.Unlock()
+		if f, ok := outputFiles[name]; ok {
+			return f, nil
+		}
+		f, err := os.OpenFile(filepath.Join(outputDir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		outputFiles[name] = f
+		return f, nil
+	}
+	// The program exits with os.Exit, so the files are closed before it does and an error that is only reported then (e.g. a full disk) fails the run
+	closeOutputFiles := func() {
+		if appendFile != nil {
+			if err := syncAndClose(appendFile); err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to write to the --append-to file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		outputFilesMu.Lock()
+		defer outputFilesMu.Unlock()
+		for name, f := range outputFiles {
+			if err := syncAndClose(f); err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to write to the --output-dir file %s: %v\n", name, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Decode the resume state
+	// In concat mode the state is prefixed with the index of the object and the number of bytes that preceded it
+	// h is the first of the hashes and is used to keep track of the position
+	var h hash.Hash
+	var hashes []hash.Hash
+	var position uint64
+	var concatIndex int
+	var concatStart uint64
+	if resume != "" {
+		if flag.NArg() > 1 && !concat {
+			fmt.Fprintln(stderr, "You can only resume hashing a single object.")
+			os.Exit(1)
+		}
+		encodedState := resume
+		if concat {
+			var err error
+			concatIndex, concatStart, encodedState, err = parseConcatResumeState(resume)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error decoding the resume state: %v\n", err)
+				os.Exit(1)
+			}
+			if concatIndex >= flag.NArg() {
+				fmt.Fprintln(stderr, "Error: The resume state refers to more objects than were provided.")
+				os.Exit(1)
+			}
+		}
+		state, err := decodeHashState(encodedState)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error decoding the resume state: %v\n", err)
+			os.Exit(1)
+		}
+		var stateAlgorithm string
+		stateAlgorithm, h, err = unmarshalHashState(state)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error unmarshaling the resume state: %v\n", err)
+			os.Exit(1)
+		}
+		if stateAlgorithm != algorithms[0] {
+			fmt.Fprintf(stderr, "Error: The resume state is for the %s algorithm, use --algorithm %s to resume it.\n", stateAlgorithm, stateAlgorithm)
+			os.Exit(1)
+		}
+		hashes = []hash.Hash{h}
+		position = hashGetLen(h)
+		if position < concatStart {
+			fmt.Fprintln(stderr, "Error: The resume state is invalid.")
+			os.Exit(1)
+		}
+		fmt.Fprintf(stderr, "Resuming from position %s.\n", formatFilesize(position, units))
+		// The hash state is only valid for the exact same byte stream
+		if !quiet {
+			var inputFlags []string
+			if versionId != "" || strings.Contains(flag.Arg(0), "?versionId=") {
+				inputFlags = append(inputFlags, "version id")
+			}
+			if len(endpointURLs) > 0 {
+				inputFlags = append(inputFlags, "--endpoint-url")
+			}
+			if len(inputFlags) > 0 {
+				fmt.Fprintf(stderr, "Warning: The %s must be the same as in the original run, or the resulting sum will be wrong.\n", strings.Join(inputFlags, " and "))
+			}
+		}
+		fmt.Fprintln(stderr)
+	}
+	// Trap Ctrl-C signal
+	// Status signals (SIGUSR1 where available) print the resume state of each object that is being hashed without stopping
+	// The workers add their status when they are created
+	workers := &workerStatuses{}
+	ctx, cancel := context.WithCancel(context.Background())
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, append([]os.Signal{os.Interrupt}, statusSignals...)...)
+	go func() {
+		interrupted := false
+		var skipped time.Time
+		for sig := range signalChannel {
+			hashing := workers.hashing()
+			if sig != os.Interrupt {
+				if len(hashing) == 0 {
+					fmt.Fprintln(stderr, "Not currently hashing an object.")
+					continue
+				}
+				for _, worker := range hashing {
+					worker.printStatus(worker.hashed.n.Load())
+				}
+				continue
+			}
+			if interrupted {
+				os.Exit(1)
+			}
+			if interruptSkips && len(hashing) > 0 && !concat && len(args) > 1 && time.Since(skipped) > interruptSkipWindow {
+				for _, worker := range hashing {
+					fmt.Fprintf(stderr, "\nInterrupt received. Skipping %s. Press Ctrl-C again within %s to stop.\n", worker.skip(), interruptSkipWindow)
+				}
+				skipped = time.Now()
+				continue
+			}
+			fmt.Fprintln(stderr, "\nInterrupt received.")
+			interrupted = true
+			cancel()
+		}
+	}()
+
+	var metrics *jobMetrics
+	if metricsAddr != "" {
+		metrics = newJobMetrics()
+		if err := startMetricsServer(ctx, metricsAddr, metrics); err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to serve the metrics: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// The spans that have not been exported yet are lost if the program exits without calling shutdownTracing
+	shutdownTracing := func() {}
+	if otelEndpoint != "" {
+		shutdown, err := startTracing(ctx, otelEndpoint)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to set up --otel-endpoint: %v\n", err)
+			os.Exit(1)
+		}
+		shutdownTracing = func() {
+			if err := shutdown(); err != nil {
+				fmt.Fprintf(stderr, "Warning: Unable to export the traces: %v\n", err)
+			}
+		}
+	}
+
+	// Initialize the AWS SDK
+	loadConfig := func(profile string) (aws.Config, error) {
+		return config.LoadDefaultConfig(
+			ctx,
+			func(o *config.LoadOptions) error {
+				if profile != "" {
+					o.SharedConfigProfile = profile
+				}
+				if noSharedConfig {
+					// An empty (non-nil) slice prevents the SDK from loading the default files
+					o.SharedConfigFiles = []string{}
+					o.SharedCredentialsFiles = []string{}
+				}
+				// Refresh credentials a while before they expire, so that long running jobs do not fail on a request made right at expiry
+				o.CredentialsCacheOptions = func(o *aws.CredentialsCacheOptions) {
+					o.ExpiryWindow = 5 * time.Minute
+				}
+				o.RetryMaxAttempts = retryMaxAttempts
+				o.RetryMode = aws.RetryMode(retryMode)
+				// The SDK ignores the retry options when a retryer is provided, so they are applied to the retryer
+				if len(retryableErrors) > 0 {
+					o.Retryer = newRetryer(retryMode, retryMaxAttempts, retryableErrors)
+				}
+				// The SDK adds the --ca-bundle to the transport of a buildable client, so the limit is kept
+				httpClient := awshttp.NewBuildableClient()
+				if maxConnsPerHost > 0 {
+					httpClient = httpClient.WithTransportOptions(func(tr *http.Transport) {
+						tr.MaxConnsPerHost = maxConnsPerHost
+					})
+					o.HTTPClient = httpClient
+				}
+				if caBundleAppend {
+					pool, err := systemCertPoolWithBundle(caBundle)
+					if err != nil {
+						fmt.Fprintf(stderr, "Error loading the CA bundle: %v\n", err)
+						os.Exit(1)
+					}
+					o.HTTPClient = httpClient.WithTransportOptions(func(tr *http.Transport) {
+						if tr.TLSClientConfig == nil {
+							tr.TLSClientConfig = &tls.Config{}
+						}
+						tr.TLSClientConfig.RootCAs = pool
+					})
+				} else if caBundle != "" {
+					f, err := os.Open(caBundle)
+					if err != nil {
+						fmt.Fprintf(stderr, "Error opening the CA bundle: %v\n", err)
+						os.Exit(1)
+					}
+					o.CustomCABundle = f
+				}
+				if noVerifySsl {
+					o.HTTPClient = &http.Client{
+						Transport: &http.Transport{
+							TLSClientConfig: &tls.Config{
+								InsecureSkipVerify: true,
+							},
+							MaxConnsPerHost: maxConnsPerHost,
+						},
+					}
+				}
+				if w, ok := stderr.(*jsonLogWriter); ok {
+					o.Logger = w
+				}
+				if debug {
+					var lm aws.ClientLogMode = aws.LogRequest | aws.LogResponse
+					o.ClientLogMode = &lm
+				}
+				return nil
+			},
+			config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+				o.TokenProvider = mfaTokenProvider
+			}),
+		)
+	}
+	cfg, err := loadConfig(profile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error initializing the AWS SDK: %v\n", err)
+		os.Exit(1)
+	}
+	if len(customHeaders) > 0 {
+		cfg.APIOptions = append(cfg.APIOptions, addHeadersMiddleware(customHeaders))
+	}
+	if trace {
+		cfg.APIOptions = append(cfg.APIOptions, traceRequestIDsMiddleware(stderr))
+	}
+	if signingRegion != "" {
+		cfg.APIOptions = append(cfg.APIOptions, signingRegionMiddleware(signingRegion))
+	}
+
+	// Buckets in --profile-map use the credentials of their own profile
+	profileCredentials := make(map[string]aws.CredentialsProvider)
+	for _, bucketProfile := range profileMap {
+		if profileCredentials[bucketProfile] != nil {
+			continue
+		}
+		profileCfg, err := loadConfig(bucketProfile)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading the profile %s: %v\n", bucketProfile, err)
+			os.Exit(1)
+		}
+		profileCredentials[bucketProfile] = profileCfg.Credentials
+	}
+	// Buckets that match a pattern in --profile-from-arn use the credentials of the assumed role
+	// The role is only assumed when it is first used, and the credentials are refreshed before they expire
+	roleCredentials := make(map[string]aws.CredentialsProvider)
+	if len(roleMap) > 0 {
+		stsClient := sts.NewFromConfig(cfg)
+		for _, r := range roleMap {
+			if roleCredentials[r.RoleARN] != nil {
+				continue
+			}
+			roleCredentials[r.RoleARN] = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, r.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = "s3sha256sum"
+				o.TokenProvider = mfaTokenProvider
+			}), func(o *aws.CredentialsCacheOptions) {
+				o.ExpiryWindow = 5 * time.Minute
+			})
+		}
+	}
+	hasBucketCredentials := func(bucket string) bool {
+		return profileMap[bucket] != "" || lookupRole(roleMap, bucket) != ""
+	}
+	bucketCredentials := func(bucket string) func(*s3.Options) {
+		return func(o *s3.Options) {
+			if noSignRequest {
+				return
+			}
+			if bucketProfile := profileMap[bucket]; bucketProfile != "" {
+				o.Credentials = profileCredentials[bucketProfile]
+			} else if roleARN := lookupRole(roleMap, bucket); roleARN != "" {
+				o.Credentials = roleCredentials[roleARN]
+			}
+		}
+	}
+	client := s3.NewFromConfig(cfg,
+		func(o *s3.Options) {
+			if noSignRequest {
+				o.Credentials = aws.AnonymousCredentials{}
+			}
+			if region != "" {
+				o.Region = region
+			}
+			if endpointURL != "" {
+				setEndpoint(o, endpointURL)
+			}
+			if usePathStyle {
+				o.UsePathStyle = true
+			}
+			if useAccelerateEndpoint {
+				o.UseAccelerate = true
+			}
+		})
+
+	// The DynamoDB tables use the default credentials, also for buckets that use other credentials
+	var dynamoDBClient *dynamodb.Client
+	if dynamoDBTable != "" || recordToDynamoDB != "" {
+		dynamoDBClient = dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+			if region != "" {
+				o.Region = region
+			}
+		})
+	}
+	var recorder *dynamoDBRecorder
+	if recordToDynamoDB != "" {
+		recorder = newDynamoDBRecorder(dynamoDBClient, recordToDynamoDB, maxRetries)
+	}
+	// The remaining items are also written when the program stops early (e.g. with --fail-fast or Ctrl-C)
+	flushRecords := func() {
+		if recorder == nil {
+			return
+		}
+		if err := recorder.flush(context.WithoutCancel(ctx)); err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to write to the DynamoDB table %s: %v\n", recordToDynamoDB, err)
+			os.Exit(1)
+		}
+	}
+
+	// Print a friendlier message for common errors, the full error is still available with --verbose
+	printObjectError := func(err error, bucket, key string) {
+		msg := describeObjectError(err, bucket, key)
+		if requestPayer == "" && isRequesterPaysError(err) {
+			msg = fmt.Sprintf("Error: The bucket %s is a requester pays bucket. Re-run with --request-payer requester (you will be charged for the requests and data transfer).", bucket)
+		}
+		if errorFormat == "json" {
+			if msg == "" {
+				msg = err.Error()
+			}
+			stderr.(*jsonLogWriter).logError(errorRecord{
+				URI:       fmt.Sprintf("s3://%s/%s", bucket, key),
+				Type:      errorType(err),
+				Message:   strings.TrimPrefix(msg, "Error: "),
+				Retryable: isRetryableError(err),
+			})
+			return
+		}
+		if msg == "" {
+			fmt.Fprintln(stderr, err)
+			if requestPayer == "" && isAccessDeniedError(err) {
+				fmt.Fprintln(stderr, "If this is a requester pays bucket, re-run with --request-payer requester (you will be charged for the requests and data transfer).")
+			}
+			return
+		}
+		fmt.Fprintln(stderr, msg)
+		if verbose || debug {
+			fmt.Fprintln(stderr, err)
+		}
+	}
+
+	// Retry requests that were throttled by S3, with exponential backoff and jitter
+	retryThrottled := func(fn func() error) error {
+		err := fn()
+		for attempt := 1; err != nil && isThrottlingError(err) && attempt <= maxRetries; attempt++ {
+			delay := retryDelay(attempt)
+			if !quiet {
+				fmt.Fprintf(stderr, "Request was throttled by S3. Retrying in %s (attempt %d of %d).\n", delay.Round(time.Millisecond), attempt, maxRetries)
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+			err = fn()
+		}
+		return err
+	}
+
+	newRegionalClient := func(bucket, bucketRegion string) *s3.Client {
+		return s3.NewFromConfig(cfg, bucketCredentials(bucket), func(o *s3.Options) {
+			o.Region = bucketRegion
+			if noSignRequest {
+				o.Credentials = aws.AnonymousCredentials{}
+			}
+			if usePathStyle {
+				o.UsePathStyle = true
+			}
+			if useAccelerateEndpoint {
+				o.UseAccelerate = true
+			}
+		})
+	}
+
+	// Cache bucket locations to avoid extra calls
+	bucketLocations := &bucketRegions{regions: make(map[string]string)}
+	// The buckets that have been checked for Transfer Acceleration
+	accelerateChecked := make(map[string]bool)
+	for bucket, bucketRegion := range regionMap {
+		bucketLocations.set(bucket, bucketRegion)
+	}
+
+	// Without permission to get the bucket location, the default region is used and requests follow the redirect to the bucket region
+	getBucketLocation := func(bucket string) (bucketRegion string, err error) {
+		spanCtx, span := tracer.Start(ctx, "GetBucketLocation")
+		defer func() {
+			span.SetAttributes(semconv.AWSS3Bucket(bucket), semconv.CloudRegion(bucketRegion))
+			endSpan(span, err)
+		}()
+		bucketLocationOutput, err := client.GetBucketLocation(spanCtx, &s3.GetBucketLocationInput{
+			Bucket: aws.String(bucket),
+		}, bucketCredentials(bucket))
+		if err != nil && isAccessDeniedError(err) {
+			bucketRegion := cfg.Region
+			if bucketRegion == "" {
+				bucketRegion = "us-east-1"
+			}
+			if verbose {
+				fmt.Fprintf(stderr, "Not allowed to get the region of the bucket %s. Trying %s.\n", bucket, bucketRegion)
+			}
+			return bucketRegion, nil
+		} else if err != nil {
+			return "", err
+		}
+		return normalizeBucketLocation(bucketLocationOutput.LocationConstraint), nil
+	}
+
+	// The manifest and the data files of the inventory report are downloaded in full before any object is hashed
+	// The objects are kept by S3Uri for --compare-inventory-checksums
+	var inventoryObjects map[string]inventoryObject
+	if inventory != "" {
+		getInventoryFile := func(bucket, key string) ([]byte, error) {
+			input := &s3.GetObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+			}
+			if expectedBucketOwner != "" {
+				input.ExpectedBucketOwner = aws.String(expectedBucketOwner)
+			}
+			fileClient := client
+			if bucketRegion := bucketLocations.get(bucket); bucketRegion != "" && endpointURL == "" {
+				fileClient = newRegionalClient(bucket, bucketRegion)
+			}
+			data, err := getObjectBytes(ctx, fileClient, input)
+			if bucketRegion := getBucketRegionFromError(err); bucketRegion != "" && bucketRegion != fileClient.Options().Region && endpointURL == "" {
+				bucketLocations.set(bucket, bucketRegion)
+				data, err = getObjectBytes(ctx, newRegionalClient(bucket, bucketRegion), input)
+			}
+			return data, err
+		}
+		var data []byte
+		var err error
+		if bucket, key, _ := parseS3Uri(inventory); bucket != "" {
+			data, err = getInventoryFile(bucket, key)
+		} else {
+			data, err = os.ReadFile(inventory)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to read the --inventory manifest: %v\n", err)
+			os.Exit(1)
+		}
+		m, err := parseInventoryManifest(data)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to parse the --inventory manifest: %v\n", err)
+			os.Exit(1)
+		}
+		if compareInventory {
+			if !slices.Contains(strings.Split(strings.ReplaceAll(m.FileSchema, " ", ""), ","), "ETag") {
+				fmt.Fprintln(stderr, "Error: The --inventory report does not have the ETag field, which is needed for --compare-inventory-checksums.")
+				os.Exit(1)
+			}
+			inventoryObjects = make(map[string]inventoryObject)
+		}
+		destinationBucket := m.destinationBucketName()
+		count := 0
+		for _, f := range m.Files {
+			data, err := getInventoryFile(destinationBucket, f.Key)
+			if err == nil {
+				err = verifyInventoryFile(data, f.MD5checksum)
+			}
+			var objects []inventoryObject
+			if err == nil {
+				objects, err = readInventoryCSV(data, m.FileSchema)
+			}
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to read the inventory data file s3://%s/%s: %v\n", destinationBucket, f.Key, err)
+				os.Exit(1)
+			}
+			for _, o := range objects {
+				args = append(args, o.URI)
+				if inventoryObjects != nil {
+					inventoryObjects[o.URI] = o
+				}
+			}
+			count += len(objects)
+		}
+		if verbose {
+			fmt.Fprintf(stderr, "The inventory report of %s lists %d objects in %d data files.\n", m.SourceBucket, count, len(m.Files))
+		}
+	}
+
+	// Look up the regions of the buckets concurrently, so that the loop does not wait for one bucket at a time
+	// A bucket that fails is looked up again when its first object is hashed, which reports the error
+	if parallelBuckets > 0 && endpointURL == "" && region == "" {
+		var pending []string
+		for _, arg := range args {
+			bucket, _, _ := parseS3Uri(arg)
+			if bucket != "" && bucketEndpoints[bucket] == "" && bucketLocations.get(bucket) == "" && !slices.Contains(pending, bucket) {
+				pending = append(pending, bucket)
+			}
+		}
+		if len(pending) > 1 {
+			var wg sync.WaitGroup
+			sem := make(chan struct{}, parallelBuckets)
+			for _, bucket := range pending {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(bucket string) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					bucketRegion, err := getBucketLocation(bucket)
+					if err != nil {
+						return
+					}
+					bucketLocations.set(bucket, bucketRegion)
+				}(bucket)
+			}
+			wg.Wait()
+		}
+	}
+
+	// Create an S3 client for the region of the bucket, the region is looked up the first time that the bucket is used
+	bucketClient := func(bucket string) (*s3.Client, error) {
+		if bucketEndpoint := bucketEndpoints[bucket]; bucketEndpoint != "" {
+			return s3.NewFromConfig(cfg, func(o *s3.Options) {
+				setEndpoint(o, bucketEndpoint)
+				o.UsePathStyle = bucketPathStyle[bucket]
+				if regionMap[bucket] != "" {
+					o.Region = regionMap[bucket]
+				} else if region != "" {
+					o.Region = region
+				}
+				if noSignRequest {
+					o.Credentials = aws.AnonymousCredentials{}
+				}
+			}, bucketCredentials(bucket)), nil
+		} else if bucketRegion := bucketLocations.get(bucket); endpointURL == "" && (region == "" || bucketRegion != "") {
+			// --region is only a hint, a bucket that was redirected to its region keeps using that region
+			if bucketRegion == "" {
+				var err error
+				bucketRegion, err = getBucketLocation(bucket)
+				if err != nil {
+					return nil, err
+				}
+				bucketLocations.set(bucket, bucketRegion)
+			}
+			return newRegionalClient(bucket, bucketRegion), nil
+		} else if hasBucketCredentials(bucket) {
+			return s3.New(client.Options(), bucketCredentials(bucket)), nil
+		}
+		return client, nil
+	}
+
+	// Lists the objects under a prefix with the client of the bucket, the listing is retried in the right region if the bucket was redirected
+	listBucketPrefix := func(bucket, prefix string) ([]s3Types.Object, *s3.Client, error) {
+		listClient, err := bucketClient(bucket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to get the bucket region: %w", err)
+		}
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		}
+		if expectedBucketOwner != "" {
+			input.ExpectedBucketOwner = aws.String(expectedBucketOwner)
+		}
+		if requestPayer != "" {
+			input.RequestPayer = s3Types.RequestPayer(requestPayer)
+		}
+		objects, err := listPrefix(ctx, listClient, input)
+		if bucketRegion := getBucketRegionFromError(err); bucketRegion != "" && bucketRegion != listClient.Options().Region && endpointURL == "" && bucketEndpoints[bucket] == "" {
+			bucketLocations.set(bucket, bucketRegion)
+			listClient = newRegionalClient(bucket, bucketRegion)
+			objects, err = listPrefix(ctx, listClient, input)
+		}
+		return objects, listClient, err
+	}
+
+	// With --recursive, the S3Uris that end with a slash (or have no key) are replaced with the objects under them
+	// The objects are hashed in the order of the listing, and the other S3Uris keep their position
+	if recursive {
+		var expanded []string
+		for _, arg := range args {
+			bucket, key, _ := parseS3Uri(arg)
+			if key != "" && !strings.HasSuffix(key, "/") {
+				expanded = append(expanded, arg)
+				continue
+			}
+			objects, _, err := listBucketPrefix(bucket, key)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to list %s: %v\n", arg, err)
+				os.Exit(1)
+			}
+			count := 0
+			for _, obj := range objects {
+				if isFolderMarker(obj) {
+					continue
+				}
+				expanded = append(expanded, fmt.Sprintf("s3://%s/%s", bucket, aws.ToString(obj.Key)))
+				count++
+			}
+			if count == 0 {
+				fmt.Fprintf(stderr, "Warning: There are no objects under %s\n", arg)
+			} else if verbose {
+				fmt.Fprintf(stderr, "Found %d objects under %s.\n", count, arg)
+			}
+		}
+		args = expanded
+	}
+
+	// Compare the listings of the two prefixes, and with --deep the sums of the objects that are under both of them
+	if objectCountMismatchCheck {
+		uris := flag.Args()
+		var sizes [2]map[string]int64
+		var clients [2]*s3.Client
+		for i, uri := range uris {
+			bucket, prefix, _ := parseS3Uri(uri)
+			objects, listClient, err := listBucketPrefix(bucket, prefix)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to list %s: %v\n", uri, err)
+				os.Exit(1)
+			}
+			sizes[i] = prefixSizes(objects, prefix)
+			clients[i] = listClient
+		}
+
+		c := comparePrefixSizes(sizes[0], sizes[1])
+		for _, key := range c.OnlyInA {
+			fmt.Fprintf(out, "Only in %s: %s\n", uris[0], key)
+		}
+		for _, key := range c.OnlyInB {
+			fmt.Fprintf(out, "Only in %s: %s\n", uris[1], key)
+		}
+		for _, key := range c.SizeDiffers {
+			fmt.Fprintf(out, "Size differs: %s (%d bytes in %s, %d bytes in %s)\n", key, c.SizesA[key], uris[0], c.SizesB[key], uris[1])
+		}
+		contentDiffers := 0
+		hashFailed := 0
+		if deep {
+			for _, key := range c.SameSize {
+				var sums [2]string
+				var err error
+				for i, uri := range uris {
+					bucket, prefix, _ := parseS3Uri(uri)
+					input := &s3.GetObjectInput{
+						Bucket: aws.String(bucket),
+						Key:    aws.String(prefix + key),
+					}
+					if expectedBucketOwner != "" {
+						input.ExpectedBucketOwner = aws.String(expectedBucketOwner)
+					}
+					if requestPayer != "" {
+						input.RequestPayer = s3Types.RequestPayer(requestPayer)
+					}
+					err = retryThrottled(func() error {
+						var err error
+						sums[i], err = downloadSum(ctx, clients[i], input)
+						return err
+					})
+					if err != nil {
+						fmt.Fprintf(stderr, "Error: Unable to hash s3://%s/%s%s: %v\n", bucket, prefix, key, err)
+						break
+					}
+				}
+				if ctx.Err() != nil {
+					os.Exit(1)
+				} else if err != nil {
+					hashFailed++
+				} else if sums[0] != sums[1] {
+					fmt.Fprintf(out, "Content differs: %s (%s in %s, %s in %s)\n", key, sums[0], uris[0], sums[1], uris[1])
+					contentDiffers++
+				} else if verbose {
+					fmt.Fprintf(stderr, "Same content: %s (%s)\n", key, sums[0])
+				}
+			}
+		}
+
+		if !compact {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "%d objects in %s, %d objects in %s.\n", len(c.SizesA), uris[0], len(c.SizesB), uris[1])
+		fmt.Fprintf(out, "%d only in %s, %d only in %s, %d with different sizes.\n", len(c.OnlyInA), uris[0], len(c.OnlyInB), uris[1], len(c.SizeDiffers))
+		if deep {
+			fmt.Fprintf(out, "%d of the %d objects with the same size have different contents.\n", contentDiffers, len(c.SameSize))
+		}
+		if hashFailed > 0 {
+			fmt.Fprintf(stderr, "Error: %d objects could not be hashed.\n", hashFailed)
+		}
+		if len(c.OnlyInA) > 0 || len(c.OnlyInB) > 0 || len(c.SizeDiffers) > 0 || contentDiffers > 0 || hashFailed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Unless --fail-fast is used, an error with one object does not stop the remaining objects from being hashed
+	// In concat mode the remaining objects are part of the same stream, so there is nothing to continue with
+	// The results of the objects are shared by the workers, resultsMu guards them
+	var resultsMu sync.Mutex
+	// The report is also written when the program stops early, with the objects that were started
+	var report *htmlReport
+	if reportHTML != "" {
+		report = &htmlReport{Started: time.Now()}
+	}
+	// With --output json the records are the only output on stdout, the other output that would be printed there is discarded
+	if outputMode == "json" {
+		out = io.Discard
+	}
+	writeReport := func() {
+		if report == nil {
+			return
+		}
+		resultsMu.Lock()
+		defer resultsMu.Unlock()
+		report.Finished = time.Now()
+		if err := report.write(reportHTML, algorithms, units); err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to write the --report-html file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Results are separated by blank lines unless --compact is used
+	printSeparator := func() {
+		if !compact {
+			fmt.Fprintln(out)
+		}
+	}
+
+	// The lines are labeled with the algorithm when there are several algorithms
+	label := func(algorithm string) string {
+		if len(algorithms) > 1 {
+			return fmt.Sprintf("%-6s  ", algorithm)
+		}
+		return ""
+	}
+
+	stoppedEarly := false
+
+	// The workers share the options, the settings and the helpers above, and the results of the objects in run
+	run := &hashRun{
+		watchInterval:           watchInterval,
+		paranoidInterval:        paranoidInterval,
+		waitTimeout:             waitTimeout,
+		verifyRetries:           verifyRetries,
+		maxRetries:              maxRetries,
+		maxConcurrentParts:      maxConcurrentParts,
+		truncate:                truncate,
+		outputMode:              outputMode,
+		restoreTier:             restoreTier,
+		recordToDynamoDB:        recordToDynamoDB,
+		dynamoDBTable:           dynamoDBTable,
+		checksumHeader:          checksumHeader,
+		sinceLastRun:            sinceLastRun,
+		manifest:                manifest,
+		compareLocal:            compareLocal,
+		progressURL:             progressURL,
+		preHashCommand:          preHashCommand,
+		postHashCommand:         postHashCommand,
+		hookFailure:             hookFailure,
+		hmacKey:                 hmacKey,
+		outputDir:               outputDir,
+		resume:                  resume,
+		versionId:               versionId,
+		expectedBucketOwner:     expectedBucketOwner,
+		requestPayer:            requestPayer,
+		outputFormat:            outputFormat,
+		storageClasses:          storageClasses,
+		algorithms:              algorithms,
+		progress:                progress,
+		writeTag:                writeTag,
+		writeMetadata:           writeMetadata,
+		force:                   force,
+		assumeYes:               assumeYes,
+		tag:                     tag,
+		compact:                 compact,
+		copyResume:              copyResume,
+		concat:                  concat,
+		decompress:              decompress,
+		normalizeCRLF:           normalizeCRLF,
+		checksumMode:            checksumMode,
+		resumeQR:                resumeQR,
+		useAccelerateEndpoint:   useAccelerateEndpoint,
+		verifyOnly:              verifyOnly,
+		headChecksum:            headChecksum,
+		listChecksums:           listChecksums,
+		printPresigned:          printPresigned,
+		onlyMissing:             onlyMissing,
+		headOnly:                headOnly,
+		checksumOnly:            checksumOnly,
+		verifyETagOnly:          verifyETagOnly,
+		kmsDecryptCheck:         kmsDecryptCheck,
+		clientSideDecrypt:       clientSideDecrypt,
+		verifyContentMD5:        verifyContentMD5,
+		reconstructETag:         reconstructETag,
+		printParts:              printParts,
+		sidecar:                 sidecar,
+		verifyParallelHashes:    verifyParallelHashes,
+		fullFingerprintFlag:     fullFingerprintFlag,
+		strictMetadata:          strictMetadata,
+		detectDuplicates:        detectDuplicates,
+		merkle:                  merkle,
+		outputOnMismatchOnly:    outputOnMismatchOnly,
+		verifyObjectLock:        verifyObjectLock,
+		requireLock:             requireLock,
+		diff:                    diff,
+		failOnAlgorithmMismatch: failOnAlgorithmMismatch,
+		verifyAndRestore:        verifyAndRestore,
+		waitForObject:           waitForObject,
+		failFast:                failFast,
+		decodeKey:               decodeKey,
+		canonicalizeKeys:        canonicalizeKeys,
+		debug:                   debug,
+		verbose:                 verbose,
+		quiet:                   quiet,
+		units:                   units,
+		syslogOutput:            syslogOutput,
+		endpointURL:             endpointURL,
+		bucketEndpoints:         bucketEndpoints,
+		sseCustomerKeyBase64:    sseCustomerKeyBase64,
+		sseCustomerKeyMD5:       sseCustomerKeyMD5,
+		outputTemplate:          outputTemplate,
+		args:                    args,
+		checkSums:               checkSums,
+		sampleBytes:             sampleBytes,
+		checkpointBytes:         checkpointBytes,
+		fromByteOffset:          fromByteOffset,
+		composite:               composite,
+		partSizeBytes:           partSizeBytes,
+		maxMemoryBytes:          maxMemoryBytes,
+		bandwidthLimiter:        bandwidthLimiter,
+		bandwidthPerPart:        bandwidthPerPart,
+		resumable:               resumable,
+		etagManifest:            etagManifest,
+		runJournal:              runJournal,
+		localSize:               localSize,
+		localPath:               localPath,
+		localFingerprint:        localFingerprint,
+		cache:                   cache,
+		appendFile:              appendFile,
+		h:                       h,
+		hashes:                  hashes,
+		position:                position,
+		concatIndex:             concatIndex,
+		concatStart:             concatStart,
+		workers:                 workers,
+		ctx:                     ctx,
+		metrics:                 metrics,
+		cfg:                     cfg,
+		dynamoDBClient:          dynamoDBClient,
+		recorder:                recorder,
+		bucketLocations:         bucketLocations,
+		inventoryObjects:        inventoryObjects,
+		report:                  report,
+		saveCache:               saveCache,
+		outputFile:              outputFile,
+		closeOutputFiles:        closeOutputFiles,
+		shutdownTracing:         shutdownTracing,
+		flushRecords:            flushRecords,
+		printObjectError:        printObjectError,
+		retryThrottled:          retryThrottled,
+		newRegionalClient:       newRegionalClient,
+		bucketClient:            bucketClient,
+		writeReport:             writeReport,
+		label:                   label,
+		resultsMu:               &resultsMu,
+		lastRun:                 lastRun,
+		accelerateChecked:       accelerateChecked,
+		duplicates:              make(map[string][]string),
+	}
+
+	// The objects are hashed in the order of the S3Uris, with --concurrency by several workers at the same time
+	if concurrency > 1 {
+		output := newOrderedOutput(os.Stdout)
+		indexes := make(chan int)
+		var wg sync.WaitGroup
+		for n := 0; n < min(concurrency, len(args)); n++ {
+			hashObject := run.newWorker(output)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range indexes {
+					hashObject(i, args[i])
+				}
+			}()
+		}
+		for i := range args {
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+	} else {
+		hashObject := run.newWorker(nil)
+		for i, arg := range args {
+			if maxObjects > 0 && run.downloadedObjects >= maxObjects {
+				fmt.Fprintln(stderr)
+				fmt.Fprintf(stderr, "Error: Stopped after downloading %d objects (--max-objects). %d of %d objects were not hashed.\n", run.downloadedObjects, len(args)-i, len(args))
+				stoppedEarly = true
+				break
+			}
+			hashObject(i, arg)
+		}
+	}
+	flushRecords()
+	closeOutputFiles()
+	shutdownTracing()
+	writeReport()
+	if detectDuplicates {
+		printSeparator()
+		printDuplicates(out, run.duplicates)
+	}
+	// The root would not cover all of the objects if some of them could not be hashed
+	if merkle && run.failures == 0 && !stoppedEarly && len(run.merkleLeaves) > 0 {
+		levels := merkleTree(run.merkleLeaves)
+		if verbose {
+			for i, level := range levels {
+				for j, node := range level {
+					fmt.Fprintf(stderr, "Merkle level %d node %d: %x\n", i, j, node)
+				}
+			}
+		}
+		printSeparator()
+		fmt.Fprintf(out, "Merkle root: %x (%d objects)\n", levels[len(levels)-1][0], len(run.merkleLeaves))
+	}
+	// Ctrl-C cancels ctx, which stops the watch and exits with the status of the first run
+	if watchInterval > 0 && len(run.watched) > 0 {
+		if !quiet {
+			fmt.Fprintf(stderr, "Watching %d objects every %s. Press Ctrl-C to stop.\n", len(run.watched), watchInterval)
+		}
+	watch:
+		for {
+			select {
+			case <-ctx.Done():
+				break watch
+			case <-time.After(watchInterval):
+			}
+			for j := range run.watched {
+				w := &run.watched[j]
+				var sum string
+				err := retryThrottled(func() error {
+					var err error
+					sum, err = downloadSum(ctx, w.Client, w.Input)
+					return err
+				})
+				now := time.Now().Format(time.RFC3339)
+				if ctx.Err() != nil {
+					break watch
+				} else if err != nil {
+					fmt.Fprintf(stderr, "%s Error: Unable to hash %s: %v\n", now, w.URI, err)
+				} else if sum != w.Sum {
+					fmt.Fprintf(out, "%s CHANGED %s (the sum was %s and is now %s)\n", now, w.URI, w.Sum, sum)
+					w.Sum = sum
+				} else if verbose {
+					fmt.Fprintf(stderr, "%s Unchanged %s\n", now, w.URI)
+				}
+			}
+		}
+	}
+	// The next run with the same arguments starts over when every object has been hashed
+	if runJournal != nil && run.failures == 0 && !stoppedEarly {
+		runJournal.file.Close()
+		if err := os.Remove(journalPath); err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to delete the --journal file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if checkSums != nil {
+		fmt.Fprintf(stderr, "%d of %d checksums OK\n", run.checkedOK, len(args))
+	}
+	if run.failures > 0 {
+		fmt.Fprintln(stderr)
+		fmt.Fprintf(stderr, "Error: %d of %d objects could not be hashed.\n", run.failures, len(args))
+		os.Exit(1)
+	}
+	if run.anyVerificationFailed || stoppedEarly {
+		os.Exit(1)
+	}
+}
+
+// Prints the objects that have the same sum, the groups are sorted by sum and the objects are in the order they were hashed
+func printDuplicates(w io.Writer, duplicates map[string][]string) {
+	var sums []string
+	for sum, uris := range duplicates {
+		if len(uris) > 1 {
+			sums = append(sums, sum)
+		}
+	}
+	if len(sums) == 0 {
+		fmt.Fprintln(w, "No duplicates found.")
+		return
+	}
+	sort.Strings(sums)
+	for i, sum := range sums {
+		if i != 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Duplicates: %s (%d objects)\n", sum, len(duplicates[sum]))
+		for _, uri := range duplicates[sum] {
+			fmt.Fprintf(w, "  %s\n", uri)
+		}
+	}
+}
+
+// An object is locked if it has a retention period that has not expired or a legal hold
+func objectLockStatus(mode s3Types.ObjectLockMode, retainUntil *time.Time, legalHold s3Types.ObjectLockLegalHoldStatus, now time.Time) (bool, string) {
+	var protections []string
+	if mode != "" && retainUntil != nil && retainUntil.After(now) {
+		protections = append(protections, fmt.Sprintf("%s retention until %s", mode, retainUntil.Format(time.RFC3339)))
+	}
+	if legalHold == s3Types.ObjectLockLegalHoldStatusOn {
+		protections = append(protections, "legal hold")
+	}
+	if len(protections) > 0 {
+		return true, "locked with " + strings.Join(protections, " and ")
+	}
+	if mode != "" && retainUntil != nil {
+		return false, fmt.Sprintf("not locked, the %s retention expired at %s", mode, retainUntil.Format(time.RFC3339))
+	}
+	return false, "not locked, the object has no retention period or legal hold"
+}
+
+func getObjectTagValue(ctx context.Context, client *s3.Client, input *s3.GetObjectTaggingInput, tagKey string) (string, error) {
+	tags, err := getObjectTags(ctx, client, input)
+	if err != nil {
+		return "", err
+	}
+	return tags[tagKey], nil
+}
+
+func getObjectTags(ctx context.Context, client *s3.Client, input *s3.GetObjectTaggingInput) (map[string]string, error) {
+	output, err := client.GetObjectTagging(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for _, t := range output.TagSet {
+		tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return tags, nil
+}
+
+func printHeadObject(w io.Writer, bucket, key, region string, head *s3.HeadObjectOutput, units unitSystem) {
+	fmt.Fprintf(w, "s3://%s/%s\n", bucket, key)
+	printField := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%-24s %s\n", name+":", value)
+		}
+	}
+	if head.ContentLength != nil {
+		printField("Size", formatFilesize(uint64(*head.ContentLength), units))
+	}
+	printField("ETag", strings.Trim(aws.ToString(head.ETag), `"`))
+	printField("Region", region)
+	if head.LastModified != nil {
+		printField("Last modified", head.LastModified.Format(time.RFC3339))
+	}
+	printField("Version id", aws.ToString(head.VersionId))
+	// S3 omits the storage class for objects in the STANDARD storage class
+	storageClass := string(head.StorageClass)
+	if storageClass == "" {
+		storageClass = string(s3Types.StorageClassStandard)
+	}
+	printField("Storage class", storageClass)
+	printField("Content type", aws.ToString(head.ContentType))
+	printField("Content encoding", aws.ToString(head.ContentEncoding))
+	printField("Server side encryption", string(head.ServerSideEncryption))
+	printField("KMS key id", aws.ToString(head.SSEKMSKeyId))
+	if head.BucketKeyEnabled != nil {
+		printField("Bucket key enabled", strconv.FormatBool(*head.BucketKeyEnabled))
+	}
+	printField("SSE-C algorithm", aws.ToString(head.SSECustomerAlgorithm))
+	printField("Object lock mode", string(head.ObjectLockMode))
+	if head.ObjectLockRetainUntilDate != nil {
+		printField("Retain until", head.ObjectLockRetainUntilDate.Format(time.RFC3339))
+	}
+	printField("Legal hold", string(head.ObjectLockLegalHoldStatus))
+	printField("Checksum CRC32", aws.ToString(head.ChecksumCRC32))
+	printField("Checksum CRC32C", aws.ToString(head.ChecksumCRC32C))
+	printField("Checksum SHA1", aws.ToString(head.ChecksumSHA1))
+	printField("Checksum SHA256", aws.ToString(head.ChecksumSHA256))
+	keys := make([]string, 0, len(head.Metadata))
+	for k := range head.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		printField("Metadata "+k, head.Metadata[k])
+	}
+}
+
+func printReconstructedETag(w io.Writer, partHash *partHasher, parts *objectParts, obj *s3.GetObjectOutput) {
+	partHash.finish()
+	objETag := strings.Trim(aws.ToString(obj.ETag), `"`)
+	etag := partHash.ETag()
+	if parts.TotalCount == 0 {
+		etag = hex.EncodeToString(partHash.md5s)
+	} else if int32(partHash.count) == parts.TotalCount {
+		fmt.Fprintf(w, "Parts:    %d (matches the object's PartsCount)\n", partHash.count)
+	} else {
+		fmt.Fprintf(w, "Parts:    %d (does not match the object's PartsCount of %d)\n", partHash.count, parts.TotalCount)
+	}
+	if etag == objETag {
+		fmt.Fprintf(w, "ETag:     %s (matches the object ETag)\n", etag)
+	} else {
+		fmt.Fprintf(w, "ETag:     %s (does not match the object ETag %s)\n", etag, objETag)
+		// The ETag is not an md5 of the data when the object is encrypted with SSE-KMS or SSE-C
+		if obj.ServerSideEncryption == s3Types.ServerSideEncryptionAwsKms || obj.SSECustomerAlgorithm != nil {
+			fmt.Fprintln(w, "Note: The ETag of an object encrypted with SSE-KMS or SSE-C is not based on the md5 of the data.")
+		}
+	}
+	if parts.Checksum == "" || len(parts.Checksums) == 0 {
+		return
+	}
+	for i, checksum := range parts.Checksums {
+		if i < partHash.count && checksum != "" && checksum != partHash.PartChecksum(i) {
+			fmt.Fprintf(w, "Part %d did not match its stored SHA256 checksum.\n", i+1)
+		}
+	}
+	if checksum := partHash.CompositeChecksum(); checksum == parts.Checksum {
+		fmt.Fprintf(w, "Checksum: %s-%d (matches the object's composite SHA256 checksum)\n", checksum, partHash.count)
+	} else {
+		fmt.Fprintf(w, "Checksum: %s-%d (does not match the object's composite SHA256 checksum %s)\n", checksum, partHash.count, parts.Checksum)
+	}
+}
+
+// Prints one line per part, marking the parts that do not match their stored SHA256 checksum
+func printPartSums(w io.Writer, partHash *partHasher, parts *objectParts) {
+	partHash.finish()
+	var start int64
+	for i := 0; i < partHash.count; i++ {
+		end := start + partHash.sizes[i]
+		line := fmt.Sprintf("Part %-5d bytes %d-%d  sha256 %x  md5 %x", i+1, start, end-1, partHash.sha256s[i*sha256.Size:(i+1)*sha256.Size], partHash.md5s[i*md5.Size:(i+1)*md5.Size])
+		if i < len(parts.Checksums) && parts.Checksums[i] != "" && parts.Checksums[i] != partHash.PartChecksum(i) {
+			line += "  (does not match the stored part checksum)"
+		}
+		fmt.Fprintln(w, line)
+		start = end
+	}
+}
+
+// Compares the MD5 of the object with the ETag and the Content-MD5 stored in the metadata
+// The Content-MD5 header is not stored by S3, so it has to be stored in the metadata when uploading (base64 or hex)
+// Returns false if any of them do not match
+func printContentMD5(w io.Writer, sum []byte, obj *s3.GetObjectOutput) bool {
+	ok := true
+	computed := hex.EncodeToString(sum)
+	fmt.Fprintf(w, "MD5:         %s\n", computed)
+	etag := strings.Trim(aws.ToString(obj.ETag), `"`)
+	if strings.Contains(etag, "-") {
+		fmt.Fprintf(w, "ETag:        %s (multipart upload, use --reconstruct-etag to compare it)\n", etag)
+	} else if obj.ServerSideEncryption == s3Types.ServerSideEncryptionAwsKms || obj.ServerSideEncryption == s3Types.ServerSideEncryptionAwsKmsDsse || obj.SSECustomerAlgorithm != nil {
+		fmt.Fprintf(w, "ETag:        %s (not an MD5 since the object is encrypted with SSE-KMS or SSE-C)\n", etag)
+	} else if etag == computed {
+		fmt.Fprintf(w, "ETag:        %s (matches)\n", etag)
+	} else {
+		fmt.Fprintf(w, "ETag:        %s (does not match)\n", etag)
+		ok = false
+	}
+	stored, found := obj.Metadata["content-md5"]
+	if !found {
+		fmt.Fprintln(w, "Content-MD5: not present in the object metadata ('content-md5')")
+		return ok
+	}
+	storedSum, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil || len(storedSum) != md5.Size {
+		storedSum, err = hex.DecodeString(stored)
+	}
+	if err != nil || len(storedSum) != md5.Size {
+		fmt.Fprintf(w, "Content-MD5: %s (not a valid MD5)\n", stored)
+		return false
+	}
+	if bytes.Equal(storedSum, sum) {
+		fmt.Fprintf(w, "Content-MD5: %s (matches)\n", hex.EncodeToString(storedSum))
+	} else {
+		fmt.Fprintf(w, "Content-MD5: %s (does not match)\n", hex.EncodeToString(storedSum))
+		ok = false
+	}
+	return ok
+}
+
+// Compares the object with the ETag and size that it had when the inventory report was made
+// The ETag is an MD5 of the object unless it was uploaded with a multipart upload or is encrypted with SSE-KMS or SSE-C
+func printInventoryComparison(w io.Writer, o inventoryObject, size uint64, sum []byte, obj *s3.GetObjectOutput) bool {
+	ok := true
+	etag := strings.Trim(aws.ToString(obj.ETag), `"`)
+	if o.ETag == etag {
+		fmt.Fprintf(w, "Inventory ETag: %s (unchanged)\n", o.ETag)
+	} else {
+		fmt.Fprintf(w, "Inventory ETag: %s (FAILED, the object changed since the inventory report, the ETag is now %s)\n", o.ETag, etag)
+		ok = false
+	}
+	if o.Size != "" {
+		if o.Size == strconv.FormatUint(size, 10) {
+			fmt.Fprintf(w, "Inventory size: %s (unchanged)\n", o.Size)
+		} else {
+			fmt.Fprintf(w, "Inventory size: %s (FAILED, the object is now %d bytes)\n", o.Size, size)
+			ok = false
+		}
+	}
+	computed := hex.EncodeToString(sum)
+	if strings.Contains(o.ETag, "-") {
+		fmt.Fprintln(w, "Inventory MD5:  NOT COMPARED (multipart upload, the ETag is not an MD5)")
+	} else if obj.ServerSideEncryption == s3Types.ServerSideEncryptionAwsKms || obj.ServerSideEncryption == s3Types.ServerSideEncryptionAwsKmsDsse || obj.SSECustomerAlgorithm != nil {
+		fmt.Fprintln(w, "Inventory MD5:  NOT COMPARED (the ETag of an object encrypted with SSE-KMS or SSE-C is not an MD5)")
+	} else if computed == o.ETag {
+		fmt.Fprintf(w, "Inventory MD5:  %s (OK)\n", computed)
+	} else {
+		fmt.Fprintf(w, "Inventory MD5:  %s (FAILED, does not match the ETag in the inventory report)\n", computed)
+		ok = false
+	}
+	return ok
+}
+
+// The sidecar object uses the sha256sum format, the sum is the first field
+// Returns an empty string if there is no sidecar object
+func getSidecarSum(ctx context.Context, client *s3.Client, input *s3.GetObjectInput) (string, error) {
+	obj, err := client.GetObject(ctx, input)
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer obj.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(obj.Body, 4*kiB))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != 2*sha256.Size {
+		return "", errors.New("the sidecar object does not start with a sha256 sum")
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", errors.New("the sidecar object does not start with a sha256 sum")
+	}
+	return fields[0], nil
+}
+
+// An object that is hashed again by --watch, Sum is the sum from the last time it was hashed
+type watchedObject struct {
+	URI    string
+	Client *s3.Client
+	Input  *s3.GetObjectInput
+	Sum    string
+}
+
+// Downloads the whole object and returns its sha256 sum
+func downloadSum(ctx context.Context, client *s3.Client, input *s3.GetObjectInput) (string, error) {
+	obj, err := client.GetObject(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, obj.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// A sum stored alongside the object, Sum is empty if it is not present
+// Note explains why a sum that is present can not be compared
+// Computed is set when the sum is compared with something other than the sha256 of the object (e.g. a composite checksum)
+type storedSum struct {
+	Source   string
+	Sum      string
+	Note     string
+	Computed string
+}
+
+// S3 stores the checksum as base64, objects uploaded with multipart uploads have a composite checksum (with a -<parts> suffix)
+// otherAlgorithms are the algorithms of the other checksums that S3 has for the object
+func nativeStoredSum(checksum string, otherAlgorithms []string) storedSum {
+	s := storedSum{Source: "S3 checksum"}
+	if checksum == "" {
+		if len(otherAlgorithms) > 0 {
+			s.Note = fmt.Sprintf("the object has a %s checksum instead of SHA256", strings.Join(otherAlgorithms, " and "))
+		}
+		return s
+	}
+	if strings.Contains(checksum, "-") {
+		s.Note = "composite checksum of a multipart upload, use --checksum-type COMPOSITE or --reconstruct-etag to compare it"
+		return s
+	}
+	b, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil || len(b) != sha256.Size {
+		s.Note = "not a sha256 checksum"
+		return s
+	}
+	s.Sum = hex.EncodeToString(b)
+	return s
+}
+
+// The sum in a response header is either the value of the header, or a sha256 parameter of it (e.g. in Content-Disposition)
+func headerStoredSum(value string) storedSum {
+	s := storedSum{Source: "Header"}
+	if _, params, err := mime.ParseMediaType(value); err == nil && params["sha256"] != "" {
+		value = params["sha256"]
+	}
+	if value == "" {
+		return s
+	}
+	b, err := hex.DecodeString(value)
+	if err != nil || len(b) != sha256.Size {
+		b, err = base64.StdEncoding.DecodeString(value)
+	}
+	if err != nil || len(b) != sha256.Size {
+		s.Note = "not a sha256 sum"
+		return s
+	}
+	s.Sum = hex.EncodeToString(b)
+	return s
+}
+
+// Prints OK, FAILED or MISSING for each location, each line starts with the prefix
+// Returns false if none of the locations have a sum
+func printStoredSums(w io.Writer, prefix, algorithm, sum string, stored []storedSum) bool {
+	present := false
+	for _, s := range stored {
+		present = present || s.Sum != ""
+	}
+	if !present {
+		fmt.Fprintf(w, "%sMetadata '%ssum' not present. Populate this metadata (or tag) to enable automatic comparison.\n", prefix, algorithm)
+		// Explain why a sum that is present could not be compared, e.g. the composite checksum of a multipart upload
+		for _, s := range stored {
+			if s.Note != "" {
+				fmt.Fprintf(w, "%s%-12s NOT COMPARED (%s)\n", prefix, s.Source+":", s.Note)
+			}
+		}
+		return false
+	}
+	for _, s := range stored {
+		name := prefix + fmt.Sprintf("%-12s", s.Source+":")
+		if s.Note != "" {
+			fmt.Fprintf(w, "%s NOT COMPARED (%s)\n", name, s.Note)
+		} else if s.Sum == "" {
+			fmt.Fprintf(w, "%s MISSING\n", name)
+		} else if s.Computed != "" && s.Computed == s.Sum {
+			fmt.Fprintf(w, "%s OK (composite)\n", name)
+		} else if s.Computed != "" {
+			fmt.Fprintf(w, "%s FAILED (computed the composite checksum %s, expected %s)\n", name, s.Computed, s.Sum)
+		} else if strings.EqualFold(sum, s.Sum) && s.Source == "S3 checksum" {
+			// The checksum was computed by S3 when the object was uploaded, so it does not depend on the uploader storing a sum
+			fmt.Fprintf(w, "%s OK (matches object checksum)\n", name)
+		} else if strings.EqualFold(sum, s.Sum) {
+			fmt.Fprintf(w, "%s OK\n", name)
+		} else {
+			fmt.Fprintf(w, "%s FAILED (expected %s)\n", name, s.Sum)
+		}
+	}
+	return true
+}
+
+// Prints the first stored sum that is present in the sha256sum format, followed by where it was found
+// A - is printed instead of the sum if none of them are present
+func printStoredSumLine(w io.Writer, uri string, stored []storedSum) {
+	for _, s := range stored {
+		if s.Sum != "" {
+			fmt.Fprintf(w, "%s  %s  (%s)\n", s.Sum, uri, strings.ToLower(s.Source))
+			return
+		}
+	}
+	for _, s := range stored {
+		if s.Note != "" {
+			fmt.Fprintf(w, "-  %s  (no stored sum, %s: %s)\n", uri, strings.ToLower(s.Source), s.Note)
+			return
+		}
+	}
+	fmt.Fprintf(w, "-  %s  (no stored sum)\n", uri)
+}
+
+// Returns the stored sums that do not match the sha256 of the object, or the composite checksum computed for it
+func mismatchedStoredSums(sum string, stored []storedSum) []storedSum {
+	var mismatched []storedSum
+	for _, s := range stored {
+		if s.Sum == "" || s.Note != "" {
+			continue
+		}
+		if (s.Computed != "" && s.Computed != s.Sum) || (s.Computed == "" && !strings.EqualFold(sum, s.Sum)) {
+			mismatched = append(mismatched, s)
+		}
+	}
+	return mismatched
+}
+
+// The line written to the --append-to file with --output-on-mismatch-only
+// The computed sum comes first, like the sha256sum format, so the line can be cut to a sum and an S3Uri
+func formatMismatchLine(uri, sum string, s storedSum) string {
+	if s.Computed != "" {
+		return fmt.Sprintf("%s  %s  (computed composite checksum, %s expected %s)", s.Computed, uri, strings.ToLower(s.Source), s.Sum)
+	}
+	return fmt.Sprintf("%s  %s  (%s expected %s)", sum, uri, strings.ToLower(s.Source), s.Sum)
+}
+
+// Returns true if a stored sum that can be compared with the sha256 of the object does not match it
+func storedSumsFailed(sum string, stored []storedSum) bool {
+	for _, s := range stored {
+		if s.Sum != "" && s.Note == "" && s.Computed == "" && !strings.EqualFold(sum, s.Sum) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns false if two of the stored sums that are present are different, regardless of the computed sum
+func storedSumsAgree(stored []storedSum) bool {
+	first := ""
+	for _, s := range stored {
+		if s.Sum == "" || s.Computed != "" {
+			continue
+		}
+		if first == "" {
+			first = s.Sum
+		} else if !strings.EqualFold(first, s.Sum) {
+			return false
 		}
 	}
+	return true
 }
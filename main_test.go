@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// The test binary runs main() with the arguments in S3SHA256SUM_TEST_ARGS when it is started by runMain
+func TestMain(m *testing.M) {
+	if args := os.Getenv("S3SHA256SUM_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"s3sha256sum"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Runs the program against the server and returns its output and exit status
+func runMain(t *testing.T, server *httptest.Server, args ...string) (string, int) {
+	t.Helper()
+	args = append([]string{"--endpoint-url", server.URL, "--use-path-style", "--region", "us-east-1", "--no-shared-config"}, args...)
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(),
+		"S3SHA256SUM_TEST_ARGS="+strings.Join(args, "\n"),
+		"AWS_ACCESS_KEY_ID=a",
+		"AWS_SECRET_ACCESS_KEY=b",
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return output.String(), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return output.String(), 0
+}
+
+// An object with the sum in its metadata and its tags
+func newStoredSumsServer(metadataSum, tagSum string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("tagging") {
+			w.Write([]byte("<Tagging><TagSet><Tag><Key>sha256sum</Key><Value>" + tagSum + "</Value></Tag></TagSet></Tagging>"))
+			return
+		}
+		w.Header().Set("x-amz-meta-sha256sum", metadataSum)
+		w.Header().Set("Content-Length", "5")
+		w.Header().Set("ETag", `"5d41402abc4b2a76b9719d911017c592"`)
+		if r.Method == http.MethodGet {
+			w.Write([]byte("hello"))
+		}
+	}))
+}
+
+func TestVerifyOnly(t *testing.T) {
+	const sum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	server := newStoredSumsServer(sum, sum)
+	defer server.Close()
+	output, status := runMain(t, server, "--verify-only", "s3://bucket/key")
+	if status != 0 || !strings.Contains(output, "OK (object metadata and tag match)") {
+		t.Fatalf("exited with status %d:\n%s", status, output)
+	}
+}
+
+func TestVerifyOnlyMismatch(t *testing.T) {
+	server := newStoredSumsServer("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", "0000000000000000000000000000000000000000000000000000000000000000")
+	defer server.Close()
+	output, status := runMain(t, server, "--verify-only", "s3://bucket/key")
+	if status != 1 || !strings.Contains(output, "FAILED (object metadata and tag do not match)") {
+		t.Fatalf("expected status 1 for a mismatch, exited with status %d:\n%s", status, output)
+	}
+}
+
+func TestOptionRules(t *testing.T) {
+	server := newStoredSumsServer("", "")
+	defer server.Close()
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--head-only", "--concat"}, "Error: --head-only can not be combined with --concat, --verify-only, --only-missing or --resume."},
+		{[]string{"--force"}, "Error: --force requires --write-tag or --write-metadata."},
+		{[]string{"--merkle", "--algorithm", "md5"}, "Error: --merkle requires the sha256 algorithm and can not be combined with --concat, --verify-only, --list-checksums, --head-only, --checksum-only, --verify-etag-only, --kms-decrypt-check, --sample, --decompress, --normalize-crlf, --from-byte or --hmac-key."},
+	}
+	for _, test := range tests {
+		output, status := runMain(t, server, append(test.args, "s3://bucket/key")...)
+		if status != 1 || !strings.Contains(output, test.expected) {
+			t.Errorf("expected %q with %v, exited with status %d:\n%s", test.expected, test.args, status, output)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/hex"
+	"sort"
+
+	"github.com/minio/sha256-simd"
+)
+
+// The Merkle tree is built from the sha256 sums of the objects, sorted by S3Uri:
+//
+//	leaf = sha256(0x00 || S3Uri || 0x00 || sum)   (sum is the 32 byte sha256 of the object)
+//	node = sha256(0x01 || left || right)
+//
+// The nodes of each level are paired from the left, and the last node of a level with an odd number of nodes
+// is moved up to the next level unchanged. The root is the single node of the last level.
+// The prefixes make a leaf impossible to mistake for a node, and the S3Uri makes the root change when an object is renamed.
+type merkleLeaf struct {
+	URI string
+	Sum string
+}
+
+// Returns the levels of the tree, from the leaves to the root
+func merkleTree(leaves []merkleLeaf) [][][]byte {
+	sorted := append([]merkleLeaf{}, leaves...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].URI < sorted[j].URI
+	})
+	level := make([][]byte, len(sorted))
+	for i, leaf := range sorted {
+		sum, _ := hex.DecodeString(leaf.Sum)
+		h := sha256.New()
+		h.Write([]byte{0x00})
+		h.Write([]byte(leaf.URI))
+		h.Write([]byte{0x00})
+		h.Write(sum)
+		level[i] = h.Sum(nil)
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i+1 < len(level); i += 2 {
+			h := sha256.New()
+			h.Write([]byte{0x01})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		if len(level)%2 == 1 {
+			next = append(next, level[len(level)-1])
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The metrics served with --metrics-addr, in the Prometheus text format
+// The bytes are counted as they are hashed, so the counter also increases while a large object is being hashed
+type jobMetrics struct {
+	objectsHashed atomic.Uint64
+	bytesHashed   atomic.Uint64
+	failures      atomic.Uint64
+	inFlight      atomic.Int64
+
+	// The throughput is the rate since the previous scrape
+	mu        sync.Mutex
+	lastBytes uint64
+	lastTime  time.Time
+}
+
+func newJobMetrics() *jobMetrics {
+	return &jobMetrics{lastTime: time.Now()}
+}
+
+// Counts the bytes that are written to it
+func (m *jobMetrics) Write(p []byte) (int, error) {
+	m.bytesHashed.Add(uint64(len(p)))
+	return len(p), nil
+}
+
+func (m *jobMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bytesHashed := m.bytesHashed.Load()
+	m.mu.Lock()
+	now := time.Now()
+	throughput := 0.0
+	if elapsed := now.Sub(m.lastTime).Seconds(); elapsed > 0 {
+		throughput = float64(bytesHashed-m.lastBytes) / elapsed
+	}
+	m.lastBytes = bytesHashed
+	m.lastTime = now
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP s3sha256sum_objects_hashed_total The number of objects that have been hashed.")
+	fmt.Fprintln(w, "# TYPE s3sha256sum_objects_hashed_total counter")
+	fmt.Fprintf(w, "s3sha256sum_objects_hashed_total %d\n", m.objectsHashed.Load())
+	fmt.Fprintln(w, "# HELP s3sha256sum_bytes_hashed_total The number of bytes that have been hashed.")
+	fmt.Fprintln(w, "# TYPE s3sha256sum_bytes_hashed_total counter")
+	fmt.Fprintf(w, "s3sha256sum_bytes_hashed_total %d\n", bytesHashed)
+	fmt.Fprintln(w, "# HELP s3sha256sum_failures_total The number of objects that could not be hashed.")
+	fmt.Fprintln(w, "# TYPE s3sha256sum_failures_total counter")
+	fmt.Fprintf(w, "s3sha256sum_failures_total %d\n", m.failures.Load())
+	fmt.Fprintln(w, "# HELP s3sha256sum_throughput_bytes_per_second The number of bytes hashed per second since the previous scrape.")
+	fmt.Fprintln(w, "# TYPE s3sha256sum_throughput_bytes_per_second gauge")
+	fmt.Fprintf(w, "s3sha256sum_throughput_bytes_per_second %g\n", throughput)
+	fmt.Fprintln(w, "# HELP s3sha256sum_in_flight_objects The number of objects that are being downloaded and hashed.")
+	fmt.Fprintln(w, "# TYPE s3sha256sum_in_flight_objects gauge")
+	fmt.Fprintf(w, "s3sha256sum_in_flight_objects %d\n", m.inFlight.Load())
+}
+
+// The address is listened on before returning, so that an address that is in use is reported before hashing starts
+// The server is shut down when ctx is cancelled
+func startMetricsServer(ctx context.Context, addr string, m *jobMetrics) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	return nil
+}
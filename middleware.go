@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Adds custom headers to every request (before the request is signed)
+func addHeadersMiddleware(headers http.Header) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("AddCustomHeaders", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				for name, values := range headers {
+					for _, value := range values {
+						req.Header.Add(name, value)
+					}
+				}
+			}
+			return next.HandleBuild(ctx, in)
+		}), middleware.After)
+	}
+}
+
+// Prints the request IDs of every response, including the responses of failed requests and of each retry attempt
+func traceRequestIDsMiddleware(w io.Writer) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("TraceRequestIDs", func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleDeserialize(ctx, in)
+			if resp, ok := out.RawResponse.(*smithyhttp.Response); ok {
+				fmt.Fprintf(w, "Trace: %s %d x-amz-request-id: %s x-amz-id-2: %s\n", awsmiddleware.GetOperationName(ctx), resp.StatusCode, resp.Header.Get("X-Amz-Request-Id"), resp.Header.Get("X-Amz-Id-2"))
+			}
+			return out, metadata, err
+		}), middleware.After)
+	}
+}
+
+// Signs the S3 requests for another region than the region that the client uses, for S3 compatible APIs that expect a specific signing region
+// The region is set where the SDK reads the legacy signing region from the context, right before the request is signed
+func signingRegionMiddleware(region string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		const relativeTo = "setLegacyContextSigningOptions"
+		if _, ok := stack.Finalize.Get(relativeTo); !ok {
+			return nil
+		}
+		return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("SigningRegion", func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			if awsmiddleware.GetServiceID(ctx) == "S3" {
+				ctx = awsmiddleware.SetSigningRegion(ctx, region)
+			}
+			return next.HandleFinalize(ctx, in)
+		}), relativeTo, middleware.Before)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A rule for an option (or for options that are used the same way), which is checked when the option is used
+// The options and the requirements are written the way they are printed in the error, main gives checkOptionRules whether each of them is used or met
+type optionRule struct {
+	option string
+	// Must be met when the option is used, e.g. "requires the sha256 algorithm"
+	requirement string
+	// The options that can not be used at the same time as the option
+	conflicts []string
+	// Printed after the conflicts to explain the rule
+	hint string
+}
+
+// The modes that only check a part of the object or only print other information than the sum
+const printsOtherOutput = "the options that only print other output"
+
+// The composite checksums are computed from the parts of the sha256 sum of the whole object
+var compositeConflicts = []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--reconstruct-etag", "--decompress", "--normalize-crlf", "--resume", "--from-byte", "--hmac-key"}
+
+// The rules are checked in this order and the error is printed for the first rule that is broken
+// A new mode must be added to the conflicts of the rules of the options that can not be used with it, and get a rule of its own
+var optionRules = []optionRule{
+	{option: "--profile and --profile-map", conflicts: []string{"--no-shared-config"}},
+	{option: "--sse-customer-key", conflicts: []string{"--sse-customer-key-file"}},
+	{option: "--tag", conflicts: []string{"--format"}},
+	// The SDK asks for the stored bytes, but a custom Accept-Encoding header could make the response use another encoding
+	{option: "--raw", conflicts: []string{"--decompress", "--normalize-crlf", "a --header that sets Accept-Encoding"}},
+	{option: "--canonicalize-key", conflicts: []string{"--decode-key"}},
+	{option: "--object-count-mismatch-check", requirement: "requires exactly two S3Uri prefixes", conflicts: []string{"--inventory", "--concat", "--compare-local", "--resume"}},
+	{option: "--deep", requirement: "requires --object-count-mismatch-check"},
+	// A resume state continues the sum of one object, which can not be matched to an object of a listing
+	{option: "--recursive", conflicts: []string{"--resume"}, hint: ", since a resume state belongs to a single object. Resume that object by giving its S3Uri without --recursive"},
+	{option: "--recursive", conflicts: []string{"--concat", "--compare-local", "--object-count-mismatch-check", "--version-id"}},
+	{option: "--from-file0", conflicts: []string{"--concat", "--resume", "--sort", "--reverse", "--object-count-mismatch-check"}},
+	// The objects in the --check file are the only objects
+	{option: "--check", conflicts: []string{"S3Uri arguments", "--from-file0", "--inventory", "--recursive", "--concat", "--resume", "--sort", "--reverse", "--object-count-mismatch-check"}},
+	{option: "--check", requirement: "can only be used with one --algorithm"},
+	{option: "--compare-inventory-checksums", requirement: "requires --inventory", conflicts: []string{"--decompress", "--normalize-crlf", "--from-byte", "--sample", "--verify-only", "--head-checksum", "--list-checksums", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check"}},
+	{option: "--inventory", conflicts: []string{"--concat", "--compare-local", "--resume", "--sort", "--reverse"}},
+	{option: "--concat", conflicts: []string{"--verify-only"}},
+	{option: "--head-only", conflicts: []string{"--concat", "--verify-only", "--only-missing", "--resume"}},
+	{option: "--print-presigned", conflicts: []string{"--concat", "--verify-only", "--list-checksums", "--head-only", "--no-sign-request", "--resume"}},
+	{option: "--head-checksum", conflicts: []string{"--concat", "--verify-only", "--list-checksums", "--head-only", "--checksum-only", "--only-missing", "--sample", "--resume"}},
+	{option: "--list-checksums", conflicts: []string{"--concat", "--verify-only", "--head-only", "--only-missing", "--resume"}},
+	{option: "--max-objects", conflicts: []string{"--concat"}},
+	{option: "--sample", requirement: "can only be used with --algorithm sha256", conflicts: []string{"the options that hash the whole object"}},
+	{option: "--checksum-only", conflicts: []string{"--concat", "--verify-only", "--head-only", "--resume", "--decompress"}},
+	{option: "--verify-etag-only", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--reconstruct-etag", "--resume", "--decompress", "--hmac-key"}},
+	{option: "--kms-decrypt-check", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--reconstruct-etag", "--verify-content-md5", "--resume", "--from-byte"}},
+	{option: "--from-byte", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--verify-content-md5", "--reconstruct-etag", "--decompress", "--resume"}},
+	// The part boundaries of a composite checksum can not be guessed, so the part size has to be specified
+	{option: "--checksum-type COMPOSITE", requirement: "requires the --part-size that the object was uploaded with"},
+	{option: "--checksum-type COMPOSITE", requirement: "requires the sha256 algorithm", conflicts: compositeConflicts},
+	{option: "--verify-parallel-hashes", requirement: "requires the --part-size that the object was uploaded with"},
+	{option: "--verify-parallel-hashes", requirement: "requires the sha256 algorithm", conflicts: compositeConflicts},
+	{option: "--report-html", conflicts: []string{"--concat", "--manifest", "--object-count-mismatch-check", printsOtherOutput}},
+	{option: "--check", conflicts: []string{"--compare-local", "--from-byte", "--sample", "--manifest", "--report-html", "--output json", "--watch", printsOtherOutput}},
+	// With --output json the records are the only output on stdout
+	{option: "--output json", conflicts: []string{"--concat", "--manifest", "--object-count-mismatch-check", "--watch", "--detect-duplicates", "--merkle", "--diff", "--tag", "--format", printsOtherOutput}},
+	// The object must be decrypted as a whole, and the S3 checksums and the ETag are of the encrypted data
+	{option: "--client-side-decrypt", conflicts: []string{"--concat", "--resume", "--from-byte", "--sample", "--max-concurrent-parts", "--checksum-mode", "--checksum-only", "--verify-etag-only", "--reconstruct-etag", "--parts", "--verify-content-md5", "--compare-inventory-checksums", "--diff"}},
+	// The full object checksum is only validated when the whole body of a single request is read
+	{option: "--max-concurrent-parts", conflicts: []string{"--checksum-mode", "--checksum-only"}},
+	{option: "--reconstruct-etag", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--decompress", "--resume"}},
+	{option: "--hmac-key", conflicts: []string{"--checksum-only", "--reconstruct-etag"}},
+	// Resuming relies on the internal state of a single hash
+	// The internal state of an HMAC also contains the key, so it is not possible to resume it
+	// The resume position is relative to the start of the object, so it can not be combined with --from-byte
+	{option: "--resume", requirement: "can only be used with a single --algorithm", conflicts: []string{"--verify-only", "--decompress", "--normalize-crlf", "--from-byte", "--hmac-key"}},
+	// The MD5 is of the data stored in S3 and is not part of the resume state
+	{option: "--verify-content-md5", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--decompress", "--normalize-crlf", "--resume"}},
+	// The hooks run for the objects that a sum is printed for
+	{option: "--pre-hash-command and --post-hash-command", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only"}},
+	// The other modes compare the data stored in S3 with checksums computed by S3
+	{option: "--normalize-crlf", conflicts: []string{"--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--reconstruct-etag"}},
+	{option: "--decompress", conflicts: []string{"--verify-only"}},
+	// The stored sums are sha256 sums of the whole object
+	{option: "--strict-metadata", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--from-byte", "--hmac-key"}},
+	{option: "--dynamodb-table", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--from-byte", "--hmac-key"}},
+	{option: "--record-to-dynamodb", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-checksum", "--list-checksums", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check", "--sample", "--print-presigned", "--decompress", "--normalize-crlf", "--from-byte", "--hmac-key"}},
+	{option: "--checksum-header", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--from-byte", "--hmac-key"}},
+	{option: "--verify-object-lock-compliance", conflicts: []string{"--concat", "--verify-only", "--list-checksums", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check", "--sample", "--manifest"}},
+	{option: "--detect-duplicates", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--list-checksums", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check", "--sample", "--from-byte", "--hmac-key"}},
+	{option: "--merkle", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--list-checksums", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check", "--sample", "--decompress", "--normalize-crlf", "--from-byte", "--hmac-key"}},
+	{option: "--verify-retries", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--decompress", "--normalize-crlf", "--from-byte", "--hmac-key"}},
+	{option: "--fail-on-checksum-algorithm-mismatch", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--decompress", "--normalize-crlf", "--from-byte", "--hmac-key"}},
+	{option: "--full-fingerprint", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--from-byte", "--hmac-key"}},
+	{option: "--manifest", requirement: "can only be used with --algorithm sha256", conflicts: []string{"--format", "--tag", "the options that print other output"}},
+	// Skipping an object would change the sum of the concatenated stream
+	{option: "--storage-class", conflicts: []string{"--concat"}},
+	{option: "--verify-and-restore", conflicts: []string{"--verify-only", "--head-only", "--head-checksum", "--list-checksums"}},
+	{option: "--only-missing", conflicts: []string{"--concat", "--verify-only"}},
+	// The sums that are written must be the sums of the data stored in S3
+	{option: "--write-tag and --write-metadata", conflicts: []string{"--concat", "--decompress", "--normalize-crlf", "--from-byte", "--hmac-key", "--sample", "the options that do not hash the whole object"}},
+	// Copying an older version would make it the current version of the object
+	{option: "--write-metadata", conflicts: []string{"--version-id"}, hint: ", use --write-tag instead"},
+	{option: "--force", requirement: "requires --write-tag or --write-metadata"},
+	{option: "--progress-url", requirement: "requires --paranoid to set the interval"},
+	{option: "--only-changed-etag", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check", "--resume"}},
+	{option: "--since-last-run", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check", "--resume", "--from-byte"}},
+	// The workers share the clients and the results, but the options that follow a single object as it is hashed can only be used by one worker
+	{option: "--concurrency", conflicts: []string{"--resume", "--paranoid", "--progress", "--concat", "--interrupt-skips", "--max-objects"}},
+	{option: "--journal", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-checksum", "--list-checksums", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check", "--sample", "--print-presigned", "--manifest", "--watch", "--resume", "--from-byte", "--hmac-key"}},
+	{option: "--compare-local", requirement: "can only be used with a single object", conflicts: []string{"--concat", "--verify-only", "--head-only", "--checksum-only", "--verify-etag-only", "--decompress", "--normalize-crlf", "--from-byte", "--hmac-key"}},
+	// The chunks are compared by their offsets in the object, so the hashed bytes must be the bytes of the whole object
+	{option: "--diff", requirement: "can only be used with --compare-local", conflicts: []string{"--concat", "--decompress", "--normalize-crlf", "--resume", "--from-byte"}},
+	{option: "--checksum-cache", requirement: "can only be used with --compare-local"},
+	{option: "--watch", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--verify-only", "--head-checksum", "--list-checksums", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check", "--sample", "--print-presigned", "--decompress", "--normalize-crlf", "--resume", "--from-byte", "--hmac-key"}},
+	{option: "--output-on-mismatch-only", requirement: "requires --append-to", conflicts: []string{"--concat", "--verify-only", "--head-checksum", "--list-checksums", "--head-only", "--checksum-only", "--verify-etag-only", "--kms-decrypt-check", "--sample", "--manifest"}},
+	{option: "--output-dir", conflicts: []string{"--concat"}},
+}
+
+// Returns the error for the first rule that is broken, or an empty string if the options follow all of the rules
+// set has whether each option is used and whether each requirement is met, a name that is missing from it is a mistake in the rules
+func checkOptionRules(rules []optionRule, set map[string]bool) string {
+	for _, rule := range rules {
+		for _, name := range append([]string{rule.option, rule.requirement}, rule.conflicts...) {
+			if _, ok := set[name]; name != "" && !ok {
+				panic(fmt.Sprintf("the option rules refer to %q, which is not in the options", name))
+			}
+		}
+	}
+	for _, rule := range rules {
+		if !set[rule.option] {
+			continue
+		}
+		broken := rule.requirement != "" && !set[rule.requirement]
+		for _, name := range rule.conflicts {
+			broken = broken || set[name]
+		}
+		if broken {
+			return rule.message()
+		}
+	}
+	return ""
+}
+
+// The whole rule, e.g. "--merkle requires the sha256 algorithm and can not be combined with --concat or --sample."
+func (r optionRule) message() string {
+	msg := r.option
+	if r.requirement != "" {
+		msg += " " + r.requirement
+		if len(r.conflicts) > 0 {
+			msg += " and"
+		}
+	}
+	if len(r.conflicts) > 0 {
+		msg += " can not be combined with " + joinOr(r.conflicts)
+	}
+	return msg + r.hint + "."
+}
+
+// Joins the names as "a, b or c"
+func joinOr(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
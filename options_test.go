@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckOptionRules(t *testing.T) {
+	rules := []optionRule{
+		{option: "--deep", requirement: "requires --object-count-mismatch-check"},
+		{option: "--merkle", requirement: "requires the sha256 algorithm", conflicts: []string{"--concat", "--sample", "--from-byte"}},
+		{option: "--write-metadata", conflicts: []string{"--version-id"}, hint: ", use --write-tag instead"},
+	}
+	tests := []struct {
+		set      map[string]bool
+		expected string
+	}{
+		{map[string]bool{}, ""},
+		{map[string]bool{"--concat": true, "--version-id": true, "requires the sha256 algorithm": true}, ""},
+		{map[string]bool{"--deep": true}, "--deep requires --object-count-mismatch-check."},
+		{map[string]bool{"--deep": true, "requires --object-count-mismatch-check": true}, ""},
+		{map[string]bool{"--merkle": true}, "--merkle requires the sha256 algorithm and can not be combined with --concat, --sample or --from-byte."},
+		{map[string]bool{"--merkle": true, "requires the sha256 algorithm": true, "--sample": true}, "--merkle requires the sha256 algorithm and can not be combined with --concat, --sample or --from-byte."},
+		{map[string]bool{"--write-metadata": true, "--version-id": true}, "--write-metadata can not be combined with --version-id, use --write-tag instead."},
+		// The error is for the first rule that is broken
+		{map[string]bool{"--deep": true, "--merkle": true}, "--deep requires --object-count-mismatch-check."},
+	}
+	for _, test := range tests {
+		// The names that are not used are false, the way main sets them
+		set := map[string]bool{}
+		for _, rule := range rules {
+			for _, name := range append([]string{rule.option, rule.requirement}, rule.conflicts...) {
+				set[name] = test.set[name]
+			}
+		}
+		if msg := checkOptionRules(rules, set); msg != test.expected {
+			t.Errorf("checkOptionRules(%v) = %q, expected %q", test.set, msg, test.expected)
+		}
+	}
+}
+
+func TestCheckOptionRulesUnknownName(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a name that is not in the options")
+		}
+	}()
+	checkOptionRules([]optionRule{{option: "--deep", conflicts: []string{"--typo"}}}, map[string]bool{"--deep": false})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/minio/sha256-simd"
+)
+
+// The part layout of a multipart object
+// Parts are only listed by GetObjectAttributes if the object was uploaded with an additional checksum,
+// otherwise the part sizes are looked up with HeadObject and a part number
+type objectParts struct {
+	Sizes      []int64
+	Checksums  []string
+	TotalCount int32
+	// The composite SHA256 checksum of the object, if it has one
+	Checksum string
+}
+
+func getObjectParts(ctx context.Context, client *s3.Client, input *s3.GetObjectAttributesInput, headInput *s3.HeadObjectInput) (*objectParts, error) {
+	parts := &objectParts{}
+	input.ObjectAttributes = []s3Types.ObjectAttributes{s3Types.ObjectAttributesObjectParts, s3Types.ObjectAttributesChecksum}
+	for {
+		attrs, err := client.GetObjectAttributes(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Checksum != nil {
+			parts.Checksum, _, _ = strings.Cut(aws.ToString(attrs.Checksum.ChecksumSHA256), "-")
+		}
+		if attrs.ObjectParts == nil {
+			// Not a multipart object
+			return parts, nil
+		}
+		parts.TotalCount = aws.ToInt32(attrs.ObjectParts.TotalPartsCount)
+		for _, p := range attrs.ObjectParts.Parts {
+			parts.Sizes = append(parts.Sizes, aws.ToInt64(p.Size))
+			parts.Checksums = append(parts.Checksums, aws.ToString(p.ChecksumSHA256))
+		}
+		if !aws.ToBool(attrs.ObjectParts.IsTruncated) {
+			break
+		}
+		input.PartNumberMarker = attrs.ObjectParts.NextPartNumberMarker
+	}
+	if len(parts.Sizes) == 0 && parts.TotalCount > 0 {
+		parts.Checksums = nil
+		for n := int32(1); n <= parts.TotalCount; n++ {
+			headInput.PartNumber = aws.Int32(n)
+			head, err := client.HeadObject(ctx, headInput)
+			if err != nil {
+				return nil, err
+			}
+			parts.Sizes = append(parts.Sizes, aws.ToInt64(head.ContentLength))
+		}
+		headInput.PartNumber = nil
+	}
+	return parts, nil
+}
+
+// The part sizes of an object that was uploaded in parts of partSize, the last part has the remaining bytes
+func fixedPartSizes(size, partSize int64) []int64 {
+	sizes := []int64{}
+	for size > partSize {
+		sizes = append(sizes, partSize)
+		size -= partSize
+	}
+	return append(sizes, size)
+}
+
+// Computes the md5 and sha256 of each part as the object is streamed through it
+type partHasher struct {
+	sizes     []int64
+	remaining int64
+	md5       hash.Hash
+	sha256    hash.Hash
+	md5s      []byte
+	sha256s   []byte
+	count     int
+}
+
+func newPartHasher(sizes []int64) *partHasher {
+	p := &partHasher{
+		sizes:  sizes,
+		md5:    md5.New(),
+		sha256: sha256.New(),
+	}
+	if len(sizes) > 0 {
+		p.remaining = sizes[0]
+	}
+	return p
+}
+
+func (p *partHasher) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if p.count >= len(p.sizes) {
+			return 0, fmt.Errorf("the object is larger than the sum of its part sizes")
+		}
+		chunk := b
+		if int64(len(chunk)) > p.remaining {
+			chunk = chunk[:p.remaining]
+		}
+		p.md5.Write(chunk)
+		p.sha256.Write(chunk)
+		p.remaining -= int64(len(chunk))
+		b = b[len(chunk):]
+		if p.remaining == 0 {
+			p.finish()
+		}
+	}
+	return n, nil
+}
+
+func (p *partHasher) finishPart() {
+	p.md5s = p.md5.Sum(p.md5s)
+	p.sha256s = p.sha256.Sum(p.sha256s)
+	p.md5.Reset()
+	p.sha256.Reset()
+	p.count++
+	if p.count < len(p.sizes) {
+		p.remaining = p.sizes[p.count]
+	}
+}
+
+// Finish the parts that are empty
+func (p *partHasher) finish() {
+	for p.count < len(p.sizes) && p.remaining == 0 {
+		p.finishPart()
+	}
+}
+
+// The ETag of a multipart object is the md5 of the concatenated part md5s, followed by the number of parts
+func (p *partHasher) ETag() string {
+	sum := md5.Sum(p.md5s)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), p.count)
+}
+
+func (p *partHasher) PartChecksum(i int) string {
+	return base64.StdEncoding.EncodeToString(p.sha256s[i*sha256.Size : (i+1)*sha256.Size])
+}
+
+// The composite checksum is the sha256 of the concatenated part sha256s
+func (p *partHasher) CompositeChecksum() string {
+	sum := sha256.Sum256(p.sha256s)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Returns the index of the first part with a different sha256, or -1 if the parts that both have are the same
+func firstDifferentPart(a, b *partHasher) int {
+	for i := 0; i < a.count && i < b.count; i++ {
+		if !bytes.Equal(a.sha256s[i*sha256.Size:(i+1)*sha256.Size], b.sha256s[i*sha256.Size:(i+1)*sha256.Size]) {
+			return i
+		}
+	}
+	return -1
+}
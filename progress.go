@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The JSON body that is POSTed to --progress-url
+// Total and Percent are omitted when the size is not known
+type progressUpdate struct {
+	URI         string  `json:"uri"`
+	BytesHashed uint64  `json:"bytes_hashed"`
+	Total       uint64  `json:"total,omitempty"`
+	Percent     float64 `json:"percent,omitempty"`
+}
+
+var progressClient = &http.Client{Timeout: 10 * time.Second}
+
+func postProgress(ctx context.Context, url string, update progressUpdate) error {
+	if update.Total != 0 {
+		update.Percent = 100 * float64(update.BytesHashed) / float64(update.Total)
+	}
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := progressClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the endpoint responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// The live progress line of --progress
+// On a terminal the line is redrawn in place and cleared when the object has been hashed, otherwise a new line is printed on each update
+type progressLine struct {
+	mu       sync.Mutex
+	w        io.Writer
+	terminal bool
+	units    unitSystem
+	active   bool
+	total    uint64
+	drawn    bool
+	// The positions of the last few seconds, for the rolling throughput
+	samples []progressSample
+}
+
+type progressSample struct {
+	time     time.Time
+	position uint64
+}
+
+// The throughput is computed over this window, so that it follows changes in the download speed
+const progressWindow = 5 * time.Second
+
+func newProgressLine(w io.Writer, terminal bool, units unitSystem) *progressLine {
+	return &progressLine{w: w, terminal: terminal, units: units}
+}
+
+// The interval between the updates, a line that is not redrawn in place is only printed now and then
+func (p *progressLine) interval() time.Duration {
+	if p.terminal {
+		return 250 * time.Millisecond
+	}
+	return 10 * time.Second
+}
+
+// Called when the download of an object starts at position, the line is only drawn between start and stop
+// The total is 0 when the size of the object is not known
+func (p *progressLine) start(position, total uint64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = true
+	p.total = total
+	p.samples = []progressSample{{time: time.Now(), position: position}}
+}
+
+// Called when the download has finished, before anything else is printed for the object
+func (p *progressLine) stop() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = false
+	if p.drawn {
+		fmt.Fprint(p.w, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+func (p *progressLine) update(position uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.active {
+		return
+	}
+	total := p.total
+	now := time.Now()
+	p.samples = append(p.samples, progressSample{time: now, position: position})
+	for len(p.samples) > 2 && now.Sub(p.samples[1].time) >= progressWindow {
+		p.samples = p.samples[1:]
+	}
+	line := formatProgress(position, total, p.units)
+	if first := p.samples[0]; position >= first.position && now.Sub(first.time) > 0 {
+		line += ", " + formatRate(float64(position-first.position)/now.Sub(first.time).Seconds(), p.units)
+	}
+	if !p.terminal {
+		fmt.Fprintln(p.w, "Hashed "+line)
+		return
+	}
+	if total != 0 {
+		const width = 20
+		filled := min(int(width*position/total), width)
+		line = "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "] " + line
+	}
+	fmt.Fprint(p.w, "\r"+line+"\033[K")
+	p.drawn = true
+}
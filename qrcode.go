@@ -0,0 +1,472 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// A minimal QR code encoder (byte mode, error correction level L, versions 1-10)
+// This is just enough to render a resume state in the terminal
+// The construction follows ISO/IEC 18004 and the approach used by Project Nayuki's QR Code generator:
+// https://www.nayuki.io/page/qr-code-generator-library
+
+const qrMaxVersion = 10
+
+// Indexed by version, for error correction level L
+var qrEccCodewordsPerBlock = [qrMaxVersion + 1]int{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18}
+var qrNumErrorCorrectionBlocks = [qrMaxVersion + 1]int{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4}
+
+type qrCode struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Renders the text as a QR code using Unicode half blocks (two rows per line)
+// Light modules are drawn, so the code is meant to be displayed on a dark terminal background
+func renderQRCode(text string) (string, error) {
+	qr, err := encodeQRCode([]byte(text))
+	if err != nil {
+		return "", err
+	}
+	const border = 4
+	dark := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= qr.size || y >= qr.size {
+			return false
+		}
+		return qr.modules[y][x]
+	}
+	var sb strings.Builder
+	for y := -border; y < qr.size+border; y += 2 {
+		for x := -border; x < qr.size+border; x++ {
+			top, bottom := !dark(x, y), !dark(x, y+1)
+			if y+1 >= qr.size+border {
+				bottom = false
+			}
+			if top && bottom {
+				sb.WriteString("█")
+			} else if top {
+				sb.WriteString("▀")
+			} else if bottom {
+				sb.WriteString("▄")
+			} else {
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+func encodeQRCode(data []byte) (*qrCode, error) {
+	// Find the smallest version that fits the data
+	version := 1
+	for ; version <= qrMaxVersion; version++ {
+		if 4+qrCharCountBits(version)+len(data)*8 <= qrNumDataCodewords(version)*8 {
+			break
+		}
+	}
+	if version > qrMaxVersion {
+		return nil, errors.New("the data is too long to fit in a QR code")
+	}
+
+	// Mode indicator (byte mode), character count, data, then the terminator and padding
+	var bits []bool
+	appendBits := func(val, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (val>>i)&1 != 0)
+		}
+	}
+	appendBits(0x4, 4)
+	appendBits(len(data), qrCharCountBits(version))
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	capacity := qrNumDataCodewords(version) * 8
+	appendBits(0, min(4, capacity-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	size := version*4 + 17
+	qr := &qrCode{
+		size:       size,
+		modules:    make([][]bool, size),
+		isFunction: make([][]bool, size),
+	}
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, size)
+		qr.isFunction[i] = make([]bool, size)
+	}
+	qr.drawFunctionPatterns(version)
+	qr.drawCodewords(qrAddEccAndInterleave(version, codewords))
+
+	// Pick the mask with the lowest penalty score
+	bestMask, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		penalty := qr.penaltyScore()
+		if bestPenalty == -1 || penalty < bestPenalty {
+			bestMask, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask) // XOR again to undo
+	}
+	qr.applyMask(bestMask)
+	qr.drawFormatBits(bestMask)
+	return qr, nil
+}
+
+func qrCharCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+func qrNumRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func qrNumDataCodewords(version int) int {
+	return qrNumRawDataModules(version)/8 - qrEccCodewordsPerBlock[version]*qrNumErrorCorrectionBlocks[version]
+}
+
+func qrAlignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*4 + numAlign*2 + 1) / (numAlign*2 - 2) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+func (qr *qrCode) setFunctionModule(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.isFunction[y][x] = true
+}
+
+func (qr *qrCode) drawFunctionPatterns(version int) {
+	// Timing patterns
+	for i := 0; i < qr.size; i++ {
+		qr.setFunctionModule(6, i, i%2 == 0)
+		qr.setFunctionModule(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns and their separators
+	for _, center := range [][2]int{{3, 3}, {qr.size - 4, 3}, {3, qr.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x < 0 || y < 0 || x >= qr.size || y >= qr.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				qr.setFunctionModule(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, except where they would overlap the finder patterns
+	positions := qrAlignmentPatternPositions(version)
+	last := len(positions) - 1
+	for i, px := range positions {
+		for j, py := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunctionModule(px+dx, py+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format bits (they are drawn once the mask is known)
+	qr.drawFormatBits(0)
+
+	// Version information
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			bit := (bits>>i)&1 != 0
+			a, b := qr.size-11+i%3, i/3
+			qr.setFunctionModule(a, b, bit)
+			qr.setFunctionModule(b, a, bit)
+		}
+	}
+}
+
+func (qr *qrCode) drawFormatBits(mask int) {
+	// Error correction level L has the format bits 01
+	data := 1<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>i)&1 != 0
+	}
+
+	// First copy, around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		qr.setFunctionModule(8, i, bit(i))
+	}
+	qr.setFunctionModule(8, 7, bit(6))
+	qr.setFunctionModule(8, 8, bit(7))
+	qr.setFunctionModule(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunctionModule(14-i, 8, bit(i))
+	}
+
+	// Second copy, split between the top right and bottom left finder patterns
+	for i := 0; i < 8; i++ {
+		qr.setFunctionModule(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunctionModule(8, qr.size-15+i, bit(i))
+	}
+	qr.setFunctionModule(8, qr.size-8, true) // Always dark
+}
+
+// Places the codewords in the zigzag pattern, starting at the bottom right corner
+func (qr *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+				if !qr.isFunction[y][x] && i < len(data)*8 {
+					qr.modules[y][x] = (data[i>>3]>>(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !qr.isFunction[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// Computes the penalty score used to select the mask (lower is better)
+func (qr *qrCode) penaltyScore() int {
+	result := 0
+	get := func(x, y int, vertical bool) bool {
+		if vertical {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < qr.size; y++ {
+			// Runs of five or more modules of the same color
+			runLength := 0
+			for x := 0; x < qr.size; x++ {
+				if x > 0 && get(x, y, vertical) == get(x-1, y, vertical) {
+					runLength++
+					if runLength == 5 {
+						result += 3
+					} else if runLength > 5 {
+						result++
+					}
+				} else {
+					runLength = 1
+				}
+			}
+			// Patterns that look like finder patterns (1:1:3:1:1 with four light modules on one side)
+			for x := 0; x+10 < qr.size; x++ {
+				pattern := []bool{true, false, true, true, true, false, true}
+				matches := true
+				for k, dark := range pattern {
+					if get(x+k, y, vertical) != dark {
+						matches = false
+						break
+					}
+				}
+				if !matches {
+					continue
+				}
+				before, after := true, true
+				for k := 1; k <= 4; k++ {
+					if x-k >= 0 && get(x-k, y, vertical) {
+						before = false
+					}
+					if x+6+k < qr.size && get(x+6+k, y, vertical) {
+						after = false
+					}
+				}
+				if before || after {
+					result += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same color
+	for y := 0; y < qr.size-1; y++ {
+		for x := 0; x < qr.size-1; x++ {
+			c := qr.modules[y][x]
+			if c == qr.modules[y][x+1] && c == qr.modules[y+1][x] && c == qr.modules[y+1][x+1] {
+				result += 3
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	dark := 0
+	for _, row := range qr.modules {
+		for _, m := range row {
+			if m {
+				dark++
+			}
+		}
+	}
+	total := qr.size * qr.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += max(k, 0) * 10
+	return result
+}
+
+// Splits the data into blocks, appends the error correction codewords to each block, and interleaves them
+func qrAddEccAndInterleave(version int, data []byte) []byte {
+	numBlocks := qrNumErrorCorrectionBlocks[version]
+	blockEccLen := qrEccCodewordsPerBlock[version]
+	rawCodewords := qrNumRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonComputeDivisor(blockEccLen)
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < numBlocks; i++ {
+		datLen := shortBlockLen - blockEccLen
+		if i >= numShortBlocks {
+			datLen++
+		}
+		dat := data[k : k+datLen]
+		k += datLen
+		block := append([]byte{}, dat...)
+		if i < numShortBlocks {
+			block = append(block, 0) // Placeholder so all blocks have the same length
+		}
+		block = append(block, reedSolomonComputeRemainder(dat, divisor)...)
+		blocks[i] = block
+	}
+
+	var result []byte
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// Skip the placeholder in the short blocks
+			if i != shortBlockLen-blockEccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+func reedSolomonComputeDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = reedSolomonMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = reedSolomonMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonComputeRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= reedSolomonMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// Multiplication in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func reedSolomonMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limits the rate that bytes are read, a limiter can be shared by several readers to limit their combined rate
+// Every read reserves the time it takes to transfer its bytes at the rate, and the reader sleeps until that time has passed
+type rateLimiter struct {
+	mu sync.Mutex
+	// Bytes per second
+	rate float64
+	// The time when the bytes that have been read so far are within the rate
+	next time.Time
+}
+
+func newRateLimiter(bytesPerSecond uint64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSecond)}
+}
+
+// Blocks until n more bytes are within the rate
+// Time that the limiter was idle is not saved up, so there are no bursts after a pause
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type rateLimitedReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	limiters []*rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	for _, l := range r.limiters {
+		if waitErr := l.wait(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
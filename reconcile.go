@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Lists all the objects under the prefix, following the continuation tokens
+// The objects are in the order that S3 returns them, which is sorted by key
+func listPrefix(ctx context.Context, client *s3.Client, input *s3.ListObjectsV2Input) ([]s3Types.Object, error) {
+	var objects []s3Types.Object
+	paginator := s3.NewListObjectsV2Paginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Contents...)
+	}
+	return objects, nil
+}
+
+// Returns the sizes of the objects by the key relative to the prefix
+func prefixSizes(objects []s3Types.Object, prefix string) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, obj := range objects {
+		sizes[strings.TrimPrefix(aws.ToString(obj.Key), prefix)] = aws.ToInt64(obj.Size)
+	}
+	return sizes
+}
+
+// Zero byte objects with a key that ends with a slash are created by the S3 console to show a folder, they are not hashed with --recursive
+func isFolderMarker(obj s3Types.Object) bool {
+	return strings.HasSuffix(aws.ToString(obj.Key), "/") && aws.ToInt64(obj.Size) == 0
+}
+
+// The result of comparing the listings of two prefixes, the keys are relative to the prefixes and sorted
+type prefixComparison struct {
+	OnlyInA        []string
+	OnlyInB        []string
+	SizeDiffers    []string
+	SameSize       []string
+	SizesA, SizesB map[string]int64
+}
+
+func comparePrefixSizes(a, b map[string]int64) prefixComparison {
+	c := prefixComparison{SizesA: a, SizesB: b}
+	for key, size := range a {
+		if sizeB, ok := b[key]; !ok {
+			c.OnlyInA = append(c.OnlyInA, key)
+		} else if size != sizeB {
+			c.SizeDiffers = append(c.SizeDiffers, key)
+		} else {
+			c.SameSize = append(c.SameSize, key)
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			c.OnlyInB = append(c.OnlyInB, key)
+		}
+	}
+	sort.Strings(c.OnlyInA)
+	sort.Strings(c.OnlyInB)
+	sort.Strings(c.SizeDiffers)
+	sort.Strings(c.SameSize)
+	return c
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"strings"
+	"time"
+)
+
+// The results of the objects for --report-html, an entry is added for each object when it is started
+// The result is one of the reportResult constants, and the sums are empty unless the object was hashed
+type htmlReport struct {
+	Started  time.Time
+	Finished time.Time
+	Objects  []*reportObject
+}
+
+// The result of an object is also the record that is printed with --output json
+// Sum is the sum of the first algorithm, and Source, Expected and Match are from its stored sums
+// They are from the first stored sum that did not match, or else the first one that did
+type reportObject struct {
+	URI          string            `json:"uri"`
+	Bucket       string            `json:"bucket"`
+	Key          string            `json:"key"`
+	VersionId    string            `json:"version_id,omitempty"`
+	Region       string            `json:"region,omitempty"`
+	Size         uint64            `json:"size"`
+	LastModified string            `json:"last_modified,omitempty"`
+	Sum          string            `json:"sum,omitempty"`
+	Sums         map[string]string `json:"sums,omitempty"`
+	Source       string            `json:"source"`
+	Expected     string            `json:"expected,omitempty"`
+	Match        *bool             `json:"match"`
+	Result       string            `json:"result"`
+}
+
+const (
+	reportSkipped     = "SKIPPED"
+	reportNotCompared = "NOT COMPARED"
+	reportNoSum       = "NO SUM"
+	reportOK          = "OK"
+	reportFailed      = "FAILED"
+	reportError       = "ERROR"
+)
+
+func newReportObject(uri, bucket, key string) *reportObject {
+	return &reportObject{URI: uri, Bucket: bucket, Key: key, Source: "none", Result: reportSkipped}
+}
+
+// The report is nil unless --report-html is used
+func (r *htmlReport) add(o *reportObject) {
+	if r != nil {
+		r.Objects = append(r.Objects, o)
+	}
+}
+
+// Records which stored sum the object was compared with, the object has no source if none of them are present
+func (o *reportObject) compare(sum string, stored []storedSum) {
+	compared := mismatchedStoredSums(sum, stored)
+	if len(compared) == 0 {
+		for _, s := range stored {
+			if s.Sum != "" && s.Note == "" {
+				compared = append(compared, s)
+			}
+		}
+	}
+	if len(compared) == 0 {
+		return
+	}
+	s := compared[0]
+	match := s.Computed == s.Sum || (s.Computed == "" && strings.EqualFold(sum, s.Sum))
+	o.Source = strings.ToLower(s.Source)
+	o.Expected = s.Sum
+	o.Match = &match
+}
+
+// The number of objects with each result, in the order of the summary table
+func (r *htmlReport) Counts() []reportCount {
+	counts := make(map[string]int)
+	for _, o := range r.Objects {
+		counts[o.Result]++
+	}
+	var summary []reportCount
+	for _, result := range []string{reportOK, reportFailed, reportError, reportNoSum, reportNotCompared, reportSkipped} {
+		if counts[result] > 0 {
+			summary = append(summary, reportCount{Result: result, Count: counts[result]})
+		}
+	}
+	return summary
+}
+
+type reportCount struct {
+	Result string
+	Count  int
+}
+
+func (r *htmlReport) write(path string, algorithms []string, units unitSystem) error {
+	var totalSize uint64
+	for _, o := range r.Objects {
+		totalSize += o.Size
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = reportTemplate.Execute(f, map[string]any{
+		"Report":     r,
+		"Algorithms": algorithms,
+		"TotalSize":  formatFilesize(totalSize, units),
+		"Duration":   r.Finished.Sub(r.Started).Round(time.Second),
+		"Version":    version,
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// The report is a single file without external resources, so that it can be sent by email or attached to a ticket
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"class": func(result string) string {
+		switch result {
+		case reportOK:
+			return "ok"
+		case reportFailed, reportError:
+			return "failed"
+		}
+		return "other"
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>s3sha256sum report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+td.sum { font-family: monospace; word-break: break-all; }
+td.size { text-align: right; }
+.ok { background: #d4edda; }
+.failed { background: #f8d7da; }
+.other { background: #fff3cd; }
+</style>
+</head>
+<body>
+<h1>s3sha256sum report</h1>
+<p>Started {{.Report.Started.Format "2006-01-02 15:04:05 MST"}}, finished {{.Report.Finished.Format "2006-01-02 15:04:05 MST"}} ({{.Duration}}). s3sha256sum version {{.Version}}.</p>
+<table>
+<tr><th>Objects</th><td>{{len .Report.Objects}}</td></tr>
+<tr><th>Total size</th><td>{{.TotalSize}}</td></tr>
+{{- range .Report.Counts}}
+<tr class="{{class .Result}}"><th>{{.Result}}</th><td>{{.Count}}</td></tr>
+{{- end}}
+</table>
+<table>
+<tr><th>Object</th><th>Size (bytes)</th>{{range .Algorithms}}<th>{{.}}</th>{{end}}<th>Result</th></tr>
+{{- range .Report.Objects}}
+{{- $o := .}}
+<tr class="{{class .Result}}"><td>{{.URI}}</td><td class="size">{{if .Sums}}{{.Size}}{{end}}</td>{{range $.Algorithms}}<td class="sum">{{index $o.Sums .}}</td>{{end}}<td>{{.Result}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// The number of days that a restored copy of an object started by --verify-and-restore is kept, it only needs to be kept until it has been hashed
+const restoreDays = 1
+
+// Objects in GLACIER and DEEP_ARCHIVE, and objects in the archive tiers of INTELLIGENT_TIERING, must be restored before they can be downloaded
+// GLACIER_IR objects can be downloaded directly
+func isArchived(head *s3.HeadObjectOutput) bool {
+	return head.StorageClass == s3Types.StorageClassGlacier || head.StorageClass == s3Types.StorageClassDeepArchive || head.ArchiveStatus != ""
+}
+
+// The x-amz-restore header is missing if no restore has been requested, otherwise it looks like:
+//
+//	ongoing-request="true"
+//	ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+func parseRestoreStatus(restore string) (ongoing, restored bool) {
+	return strings.Contains(restore, `ongoing-request="true"`), strings.Contains(restore, `ongoing-request="false"`)
+}
+
+// S3 responds with RestoreAlreadyInProgress if the object is already being restored, which is not an error here
+// The number of days can not be given for objects in INTELLIGENT_TIERING, which move back to the frequent access tier instead
+func restoreObject(ctx context.Context, client *s3.Client, head *s3.HeadObjectOutput, input *s3.HeadObjectInput, tier string) error {
+	request := &s3Types.RestoreRequest{
+		GlacierJobParameters: &s3Types.GlacierJobParameters{
+			Tier: s3Types.Tier(tier),
+		},
+	}
+	if head.StorageClass != s3Types.StorageClassIntelligentTiering {
+		request.Days = aws.Int32(restoreDays)
+	}
+	_, err := client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:              input.Bucket,
+		Key:                 input.Key,
+		VersionId:           input.VersionId,
+		ExpectedBucketOwner: input.ExpectedBucketOwner,
+		RequestPayer:        input.RequestPayer,
+		RestoreRequest:      request,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return err
+}
+
+// Expedited restores usually complete within minutes, the other tiers take hours
+func restorePollInterval(tier string) time.Duration {
+	if tier == string(s3Types.TierExpedited) {
+		return 15 * time.Second
+	}
+	return time.Minute
+}
+
+// Returns the expiry-date of a completed restore, or "never" for INTELLIGENT_TIERING objects, which do not have one
+func restoreExpiry(restore string) string {
+	_, expiry, ok := strings.Cut(restore, `expiry-date="`)
+	if !ok {
+		return "never"
+	}
+	expiry, _, _ = strings.Cut(expiry, `"`)
+	return expiry
+}
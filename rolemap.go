@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// The file used by --profile-from-arn maps bucket name patterns to the role to assume for them:
+//
+//	# <bucket pattern> <role ARN>
+//	prod-*     arn:aws:iam::111111111111:role/audit
+//	backup-??  arn:aws:iam::222222222222:role/audit
+//	*          arn:aws:iam::333333333333:role/audit
+//
+// The patterns use the same syntax as path.Match, and the first pattern that matches the bucket name is used
+type roleMapping struct {
+	Pattern string
+	RoleARN string
+}
+
+func loadRoleMap(filename string) ([]roleMapping, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var roles []roleMapping
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a bucket pattern and a role ARN", filename, n)
+		}
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid bucket pattern %q", filename, n, fields[0])
+		}
+		if !strings.HasPrefix(fields[1], "arn:") {
+			return nil, fmt.Errorf("%s:%d: invalid role ARN %q", filename, n, fields[1])
+		}
+		roles = append(roles, roleMapping{Pattern: fields[0], RoleARN: fields[1]})
+	}
+	return roles, scanner.Err()
+}
+
+// Returns the role ARN for the bucket, or an empty string if no pattern matches
+func lookupRole(roles []roleMapping, bucket string) string {
+	for _, r := range roles {
+		if ok, _ := path.Match(r.Pattern, bucket); ok {
+			return r.RoleARN
+		}
+	}
+	return ""
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Send SIGUSR1 to print the current resume state without interrupting the hashing
+var statusSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows has no SIGUSR1 equivalent
+var statusSignals = []os.Signal{}
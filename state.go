@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The state file used by --since-last-run
+// Each line has the ETag, the time the object was hashed (RFC 3339), the sum and the S3Uri, separated by tabs
+// The S3Uri is last since it is the only field that can contain spaces
+type runState map[string]runStateEntry
+
+type runStateEntry struct {
+	ETag     string
+	HashedAt time.Time
+	Sum      string
+}
+
+// A state file that does not exist yet is treated as empty
+func loadRunState(path string) (runState, error) {
+	state := make(runState)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: expected 4 fields separated by tabs", path, n)
+		}
+		hashedAt, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid time %q", path, n, fields[1])
+		}
+		state[fields[3]] = runStateEntry{ETag: fields[0], HashedAt: hashedAt, Sum: fields[2]}
+	}
+	return state, scanner.Err()
+}
+
+// The file is replaced atomically so that an interrupted run does not leave a truncated state file
+func (s runState) save(path string) error {
+	uris := make([]string, 0, len(s))
+	for uri := range s {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	var sb strings.Builder
+	sb.WriteString("# s3sha256sum --since-last-run state: <etag> <hashed at> <sha256> <S3Uri>\n")
+	for _, uri := range uris {
+		e := s[uri]
+		fmt.Fprintf(&sb, "%s\t%s\t%s\t%s\n", e.ETag, e.HashedAt.UTC().Format(time.RFC3339), e.Sum, uri)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(sb.String())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// The manifest used by --only-changed-etag has the ETag and the S3Uri of an object on each line, separated by whitespace:
+//
+//	9b2cf535f27731c974343645a3985328  s3://mybucket/file.txt
+//	d41d8cd98f00b204e9800998ecf8427e-12  s3://mybucket/big.iso?versionId=abc
+//
+// This is the output of --format '{{.ETag}}  s3://{{.Bucket}}/{{.Key}}', the quotes around the ETag are optional
+// An ETag of a multipart upload (with a -<parts> suffix) depends on the part size, so an object that was uploaded again with another part size is hashed again
+func loadETagManifest(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		etag, uri, ok := strings.Cut(line, " ")
+		uri = strings.TrimSpace(uri)
+		if !ok || !strings.HasPrefix(uri, "s3://") {
+			return nil, fmt.Errorf("%s:%d: expected an ETag and an S3Uri", path, n)
+		}
+		manifest[uri] = normalizeETag(etag)
+	}
+	return manifest, scanner.Err()
+}
+
+func normalizeETag(etag string) string {
+	return strings.ToLower(strings.Trim(etag, `"`))
+}
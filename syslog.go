@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// The methods of *syslog.Writer that are used, so that the platforms without a system log can provide a stub
+type sysLogger interface {
+	Info(m string) error
+	Warning(m string) error
+	Err(m string) error
+}
+
+// Sends each line written to it to the system log
+// The priority is derived from the "Error" and "Warning:" prefixes used in the messages
+type syslogWriter struct {
+	mu     sync.Mutex
+	buf    []byte
+	logger sysLogger
+}
+
+func newSyslogWriter(logger sysLogger) *syslogWriter {
+	return &syslogWriter{logger: logger}
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			break
+		}
+		line := strings.TrimSpace(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+		if line == "" {
+			continue
+		}
+		// Failing to log is not a reason to stop hashing
+		if strings.HasPrefix(line, "Error") {
+			w.logger.Err(line)
+		} else if strings.HasPrefix(line, "Warning:") {
+			w.logger.Warning(line)
+		} else {
+			w.logger.Info(line)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+func openSyslog() (sysLogger, error) {
+	return nil, errors.New("the system log is not supported on this platform")
+}
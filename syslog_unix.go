@@ -0,0 +1,9 @@
+//go:build !windows && !plan9
+
+package main
+
+import "log/syslog"
+
+func openSyslog() (sysLogger, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "s3sha256sum")
+}
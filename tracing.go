@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The global tracer provider is a no-op unless --otel-endpoint is used, so the spans cost next to nothing when tracing is off
+var tracer = otel.Tracer("github.com/stefansundin/s3sha256sum")
+
+// The spans are exported with OTLP over HTTP, the path defaults to /v1/traces when the URL has no path
+// Returns a function that exports the remaining spans, which must be called before the program exits
+func startTracing(ctx context.Context, endpoint string) (func() error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s is not an http:// or https:// URL", endpoint)
+	}
+	if strings.TrimSuffix(u.Path, "/") == "" {
+		u.Path = "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(u.String()))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("s3sha256sum"), semconv.ServiceVersion(version)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return func() error {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return tp.Shutdown(shutdownCtx)
+	}, nil
+}
+
+// The span that covers everything that is done for one object, the GetObject and hash spans are its children
+type objectSpan struct {
+	span  trace.Span
+	start time.Time
+	ended bool
+}
+
+func startObjectSpan(ctx context.Context, bucket, key string) (context.Context, *objectSpan) {
+	ctx, span := tracer.Start(ctx, "object", trace.WithAttributes(
+		semconv.AWSS3Bucket(bucket),
+		semconv.AWSS3Key(key),
+	))
+	return ctx, &objectSpan{span: span, start: time.Now()}
+}
+
+func (s *objectSpan) setSize(size uint64) {
+	s.span.SetAttributes(attribute.Int64("s3sha256sum.size", int64(size)))
+}
+
+// The result is OK, FAILED (a verification failed), error (the object could not be hashed) or skipped
+// Only the first call has an effect, so the span can be ended both where an error happens and where the next object starts
+func (s *objectSpan) end(result string) {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	s.span.SetAttributes(
+		attribute.String("s3sha256sum.result", result),
+		attribute.Int64("s3sha256sum.duration_ms", time.Since(s.start).Milliseconds()),
+	)
+	if result != "OK" && result != "skipped" {
+		s.span.SetStatus(codes.Error, result)
+	}
+	s.span.End()
+}
+
+// Ends a span and records the error on it, if there is one
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -1,11 +1,35 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 const kiB = 1024
@@ -13,52 +37,171 @@ const MiB = 1024 * kiB
 const GiB = 1024 * MiB
 const TiB = 1024 * GiB
 
-func parseS3Uri(s string) (string, string) {
+// Returns the bucket, key and versionId
+// URIs copied from the S3 console may end with ?versionId=<id>
+func parseS3Uri(s string) (string, string, string) {
 	if !strings.HasPrefix(s, "s3://") {
-		return "", ""
+		return "", "", ""
 	}
 	parts := strings.SplitN(s[5:], "/", 2)
 	if len(parts) == 0 {
-		return "", ""
+		return "", "", ""
 	} else if len(parts) == 1 {
-		return parts[0], ""
-	} else {
-		return parts[0], parts[1]
+		return parts[0], "", ""
+	}
+	key, versionId := parts[1], ""
+	if i := strings.LastIndex(key, "?versionId="); i != -1 {
+		key, versionId = key[:i], key[i+len("?versionId="):]
 	}
+	return parts[0], key, versionId
+}
+
+// The units used to format sizes
+// The AWS console shows sizes in decimal units
+type unitSystem int
+
+const (
+	binaryUnits unitSystem = iota
+	decimalUnits
+)
+
+func (u unitSystem) base() (uint64, []string) {
+	if u == decimalUnits {
+		return 1000, []string{"kB", "MB", "GB", "TB"}
+	}
+	return kiB, []string{"kiB", "MiB", "GiB", "TiB"}
 }
 
 // The S3 docs state GB and TB but they actually mean GiB and TiB
-// For consistency, format filesizes in GiB and TiB
-func formatFilesize(size uint64) string {
-	if size < kiB {
+// For consistency, format filesizes in GiB and TiB unless decimal units are requested
+func formatFilesize(size uint64, units unitSystem) string {
+	base, names := units.base()
+	if size < base {
 		return fmt.Sprintf("%d bytes", size)
-	} else if size < MiB {
-		return fmt.Sprintf("%.1f kiB (%d bytes)", float64(size)/float64(kiB), size)
-	} else if size < GiB {
-		return fmt.Sprintf("%.1f MiB (%d bytes)", float64(size)/float64(MiB), size)
-	} else if size < TiB {
-		return fmt.Sprintf("%.1f GiB (%d bytes)", float64(size)/float64(GiB), size)
-	} else {
-		return fmt.Sprintf("%.1f TiB (%d bytes)", float64(size)/float64(TiB), size)
 	}
+	unit := base
+	for i, name := range names {
+		if size/unit < base || i == len(names)-1 {
+			return fmt.Sprintf("%.1f %s (%d bytes)", float64(size)/float64(unit), name, size)
+		}
+		unit *= base
+	}
+	return ""
 }
 
-func formatResumeCommand(encodedState, arg string) string {
+// Parses a size such as 1073741824, 512MiB or 1GiB (the units are binary, so 1GB is the same as 1GiB)
+func parseFilesize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i == -1 {
+		return strconv.ParseUint(s, 10, 64)
+	}
+	n, err := strconv.ParseUint(s[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	var unit uint64
+	switch strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(s[i:], "B"), "i")) {
+	case "":
+		unit = 1
+	case "k":
+		unit = kiB
+	case "m":
+		unit = MiB
+	case "g":
+		unit = GiB
+	case "t":
+		unit = TiB
+	default:
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxUint64/unit {
+		return 0, fmt.Errorf("the size %q is too large", s)
+	}
+	return n * unit, nil
+}
+
+// A total of 0 means that the size is unknown
+func formatProgress(position, total uint64, units unitSystem) string {
+	if total == 0 {
+		return formatFilesize(position, units)
+	}
+	return fmt.Sprintf("%s out of %s (%2.1f%%)", formatFilesize(position, units), formatFilesize(total, units), 100*float64(position)/float64(total))
+}
+
+// The rate is in bytes per second, and is formatted in kiB/s or MiB/s (kB/s or MB/s with --si)
+func formatRate(rate float64, units unitSystem) string {
+	base, names := units.base()
+	if rate < float64(base*base) {
+		return fmt.Sprintf("%.1f %s/s", rate/float64(base), names[0])
+	}
+	return fmt.Sprintf("%.1f %s/s", rate/float64(base*base), names[1])
+}
+
+// Only the bytes hashed since the download started are used, so a resumed job is not skewed by the position it resumed from
+// A remaining of 0 means that the size is unknown
+func formatThroughput(sessionBytes uint64, elapsed time.Duration, remaining uint64, units unitSystem) string {
+	if sessionBytes == 0 || elapsed <= 0 {
+		return ""
+	}
+	rate := float64(sessionBytes) / elapsed.Seconds()
+	s := "Hashing at " + formatRate(rate, units)
+	if remaining != 0 {
+		eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+		s += fmt.Sprintf(", about %s remaining", eta.Round(time.Second))
+	}
+	return s + "."
+}
+
+func formatResumeCommand(encodedState string, args ...string) string {
 	cmd := []string{os.Args[0], "--resume", encodedState}
 	for i := 1; i < len(os.Args); i++ {
 		if os.Args[i] == "--resume" {
 			i++
 			continue
 		}
+		if os.Args[i] == "--resume-clipboard" {
+			continue
+		}
 		if strings.HasPrefix(os.Args[i], "s3://") {
 			continue
 		}
 		cmd = append(cmd, os.Args[i])
 	}
-	cmd = append(cmd, arg)
+	cmd = append(cmd, args...)
 	return strings.Join(cmd, " ")
 }
 
+// The concat resume state has the format <index>:<start>:<state>
+func formatConcatResumeState(index int, start uint64, encodedState string) string {
+	return fmt.Sprintf("%d:%d:%s", index, start, encodedState)
+}
+
+func parseConcatResumeState(s string) (int, uint64, string, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", errors.New("the resume state was not produced with --concat")
+	}
+	index, err := strconv.Atoi(parts[0])
+	if err != nil || index < 0 {
+		return 0, 0, "", fmt.Errorf("invalid object index: %s", parts[0])
+	}
+	start, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid object start position: %s", parts[1])
+	}
+	return index, start, parts[2], nil
+}
+
+// The manifest rows are CSV records, keys that contain commas, quotes or newlines are quoted
+func formatManifestRow(fields ...string) string {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write(fields)
+	w.Flush()
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
 // https://github.com/aws/aws-sdk-go/blob/e2d6cb448883e4f4fcc5246650f89bde349041ec/service/s3/bucket_location.go#L15-L32
 // Would be nice if aws-sdk-go-v2 supported this.
 func normalizeBucketLocation(loc s3Types.BucketLocationConstraint) string {
@@ -68,6 +211,308 @@ func normalizeBucketLocation(loc s3Types.BucketLocationConstraint) string {
 	return string(loc)
 }
 
+// Returns a friendly description of errors that are common when getting an object, or an empty string
+func describeObjectError(err error, bucket, key string) string {
+	var noSuchKey *s3Types.NoSuchKey
+	var notFound *s3Types.NotFound
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchBucket" {
+		return fmt.Sprintf("Error: The bucket %s does not exist.", bucket)
+	} else if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRequest" && strings.Contains(apiErr.ErrorMessage(), "Transfer Acceleration") {
+		return fmt.Sprintf("Error: Transfer Acceleration is not enabled on the bucket %s. Enable it on the bucket or remove --use-accelerate-endpoint.", bucket)
+	} else if isDeleteMarkerError(err) {
+		return fmt.Sprintf("Error: The object s3://%s/%s is a delete marker, which has no data to hash. Use --version-id to select an earlier version.", bucket, key)
+	} else if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return fmt.Sprintf("Error: The object s3://%s/%s does not exist (or you lack permission to access it).", bucket, key)
+	} else if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState" {
+		return fmt.Sprintf("Error: The object s3://%s/%s is archived and must be restored before it can be hashed. Use --verify-and-restore to restore it.", bucket, key)
+	} else if isExpiredCredentialsError(err) {
+		return "Error: The credentials have expired and could not be refreshed. If you are using SSO, run aws sso login and try again."
+	}
+	return ""
+}
+
+// The error code sent by S3 (e.g. NoSuchKey or AccessDenied), or a generic type for errors that did not come from S3
+func errorType(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	} else if errors.Is(err, context.Canceled) {
+		return "Canceled"
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return "Timeout"
+	}
+	return "Error"
+}
+
+// Whether the SDK would consider the error retryable (e.g. throttling, server errors and connection errors)
+func isRetryableError(err error) bool {
+	return isThrottlingError(err) || retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// The SDK only exposes the headers it knows about, the others are read from the raw response
+func getResponseHeader(metadata middleware.Metadata, name string) string {
+	if resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok {
+		return resp.Header.Get(name)
+	}
+	return ""
+}
+
+// HeadObject responds with NotFound and GetObject with NoSuchKey
+func isNotFoundError(err error) bool {
+	var noSuchKey *s3Types.NoSuchKey
+	var notFound *s3Types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
+
+// S3 responds with x-amz-delete-marker when the current version (404) or the requested version (405) is a delete marker
+func isDeleteMarkerError(err error) bool {
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.Response != nil && respErr.Response.Header.Get("X-Amz-Delete-Marker") == "true"
+}
+
+// Returns the names of the checksums that are present
+func checksumAlgorithms(crc32, crc32c, sha1 *string) []string {
+	var algorithms []string
+	if aws.ToString(crc32) != "" {
+		algorithms = append(algorithms, "CRC32")
+	}
+	if aws.ToString(crc32c) != "" {
+		algorithms = append(algorithms, "CRC32C")
+	}
+	if aws.ToString(sha1) != "" {
+		algorithms = append(algorithms, "SHA1")
+	}
+	return algorithms
+}
+
+// S3 returns the errors from KMS with a KMS. prefix (e.g. KMS.DisabledException)
+// When the key policy does not allow the caller to use the key, it is an AccessDenied error that mentions KMS
+func isKMSError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if strings.HasPrefix(apiErr.ErrorCode(), "KMS.") {
+		return true
+	}
+	return apiErr.ErrorCode() == "AccessDenied" && strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "kms")
+}
+
+// Returns the message of an API error without the request details, or the full error
+func errorMessage(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorMessage() != "" {
+		return apiErr.ErrorMessage()
+	}
+	return err.Error()
+}
+
+func isAccessDeniedError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDenied"
+}
+
+// Requests to requester pays buckets without x-amz-request-payer are denied, sometimes with a message that mentions it
+func isRequesterPaysError(err error) bool {
+	var apiErr smithy.APIError
+	if !isAccessDeniedError(err) || !errors.As(err, &apiErr) {
+		return false
+	}
+	msg := strings.ToLower(apiErr.ErrorMessage())
+	return strings.Contains(msg, "requester pays") || strings.Contains(msg, "request payment") || strings.Contains(msg, "request-payer")
+}
+
+// SSO tokens are refreshed by the SDK when the profile uses an sso_session, otherwise a new login is required
+func isExpiredCredentialsError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ExpiredToken", "ExpiredTokenException", "TokenRefreshRequired":
+			return true
+		}
+	}
+	return strings.Contains(err.Error(), "failed to refresh cached credentials")
+}
+
+// SSE-C uses AES256, so the key must be exactly 32 bytes
+// The key can be provided as hex (64 characters) or base64
+func decodeSSECustomerKey(s string) ([]byte, error) {
+	var key []byte
+	var err error
+	if len(s) == 64 {
+		key, err = hex.DecodeString(s)
+	} else {
+		key, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, errors.New("the key must be encoded as base64 or hex")
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("the key must be 256 bits (32 bytes), got %d bytes", len(key))
+	}
+	return key, nil
+}
+
+// S3 includes the bucket region in the response when a request is sent to the wrong region (e.g. 301 PermanentRedirect)
+func getBucketRegionFromError(err error) string {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		return respErr.Response.Header.Get("X-Amz-Bucket-Region")
+	}
+	return ""
+}
+
+func isThrottlingError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "SlowDown" || apiErr.ErrorCode() == "ServiceUnavailable") {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusServiceUnavailable
+}
+
+// Counts the bytes that are written to the hashes, so that other goroutines can read the position while the object is hashed
+type hashPosition struct {
+	n atomic.Uint64
+}
+
+func (c *hashPosition) Write(p []byte) (int, error) {
+	c.n.Add(uint64(len(p)))
+	return len(p), nil
+}
+
+// Holds mu while writing to w, so that the state of the hashes can be marshaled from another goroutine
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// Calls checkpoint every time the position reaches a multiple of interval
+// Writes are split at the multiples so that the hash state is exactly at the checkpoint position
+type checkpointWriter struct {
+	w          io.Writer
+	position   uint64
+	interval   uint64
+	checkpoint func(position uint64)
+}
+
+func (c *checkpointWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if untilNext := c.interval - c.position%c.interval; uint64(len(chunk)) > untilNext {
+			chunk = chunk[:untilNext]
+		}
+		n, err := c.w.Write(chunk)
+		written += n
+		c.position += uint64(n)
+		if err != nil {
+			return written, err
+		}
+		if c.position%c.interval == 0 {
+			c.checkpoint(c.position)
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// The SDK does not export its checksum validation error
+func isChecksumMismatchError(err error) bool {
+	return strings.Contains(err.Error(), "checksum did not match")
+}
+
+// Exponential backoff with full jitter, capped at 30 seconds
+func retryDelay(attempt int) time.Duration {
+	backoff := time.Second << min(attempt-1, 5)
+	return min(time.Duration(rand.Int63n(int64(backoff)))+100*time.Millisecond, 30*time.Second)
+}
+
+// A retryer that also retries the given error codes, with the --retry-mode and --retry-max-attempts applied to it
+func newRetryer(retryMode string, maxAttempts int, errorCodes []string) func() aws.Retryer {
+	return func() aws.Retryer {
+		standardOptions := func(so *retry.StandardOptions) {
+			if maxAttempts > 0 {
+				so.MaxAttempts = maxAttempts
+			}
+		}
+		if retryMode == string(aws.RetryModeAdaptive) {
+			return retry.AddWithErrorCodes(retry.NewAdaptiveMode(func(ao *retry.AdaptiveModeOptions) {
+				ao.StandardOptions = append(ao.StandardOptions, standardOptions)
+			}), errorCodes...)
+		}
+		return retry.AddWithErrorCodes(retry.NewStandard(standardOptions), errorCodes...)
+	}
+}
+
+// Close does not report every write error (e.g. on a network filesystem), so the file is synced first
+// Files that can not be synced (e.g. a pipe) are only closed
+func syncAndClose(f *os.File) error {
+	err := f.Sync()
+	if errors.Is(err, syscall.EINVAL) {
+		err = nil
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// The SDK replaces the system certificates with the CA bundle, this adds the bundle to them instead
+func systemCertPoolWithBundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates were found in %s", path)
+	}
+	return pool, nil
+}
+
+func newUnixSocketHTTPClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func isLocalhost(hostname string) bool {
+	if hostname == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Region names consist of lowercase letters, digits and dashes (e.g. us-west-2)
+func isValidRegion(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
 func isNumeric(s string) bool {
 	for _, c := range s {
 		if c < '0' || c > '9' {
@@ -77,14 +522,142 @@ func isNumeric(s string) bool {
 	return true
 }
 
+// A pipe or a file is not a terminal that a question can be asked on
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// The questions are asked one at a time, also when several workers ask at the same time
+var promptMu sync.Mutex
+
+// Anything other than y or yes is a no
+func confirm(question string) bool {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 func mfaTokenProvider() (string, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
 	for {
-		fmt.Printf("Assume Role MFA token code: ")
+		fmt.Fprint(os.Stderr, "Assume Role MFA token code: ")
 		var code string
 		_, err := fmt.Scanln(&code)
 		if len(code) == 6 && isNumeric(code) {
 			return code, err
 		}
-		fmt.Println("Code must consist of 6 digits. Please try again.")
+		fmt.Fprintln(os.Stderr, "Code must consist of 6 digits. Please try again.")
+	}
+}
+
+// Converts CRLF line endings to LF
+// A CR at the end of a read is held back until it is known whether it is followed by a LF
+type crlfReader struct {
+	r  io.Reader
+	cr bool
+}
+
+func (c *crlfReader) Read(p []byte) (int, error) {
+	if len(p) < 2 {
+		return 0, io.ErrShortBuffer
+	}
+	for {
+		start := 0
+		if c.cr {
+			p[0] = '\r'
+			start = 1
+			c.cr = false
+		}
+		n, err := c.r.Read(p[start:])
+		n += start
+		out := 0
+		for i := 0; i < n; i++ {
+			if p[i] == '\r' {
+				if i+1 < n && p[i+1] == '\n' {
+					continue
+				}
+				if i+1 == n && err == nil {
+					c.cr = true
+					continue
+				}
+			}
+			p[out] = p[i]
+			out++
+		}
+		if out > 0 || err != nil {
+			return out, err
+		}
 	}
 }
+
+// Returns the first byte of a Content-Range header (e.g. "bytes 100-199/200")
+func parseContentRangeStart(s string) (uint64, bool) {
+	s, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(start, 10, 64)
+	return n, err == nil
+}
+
+// Reads the NUL separated S3Uris of --from-file0 from the file, or from stdin if the path is -
+// A NUL after the last S3Uri is optional, like the output of find -print0
+func readNullSeparated(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00"), nil
+}
+
+// Keys can contain newlines, which would break the line based output
+// Like sha256sum, a key with a newline or carriage return is printed with \n, \r and \\ escapes, and the line starts with a backslash
+func escapeKey(key string) (string, bool) {
+	if !strings.ContainsAny(key, "\n\r") {
+		return key, false
+	}
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(key), true
+}
+
+// The normalization of --canonicalize-key: the percent-encoded bytes (e.g. %20) are decoded, and a % that does not start one is kept
+// A + is kept, since it is a valid character in a key and only means a space in the query string of a URL
+// The key is returned unchanged if the decoded key is not valid UTF-8, since it was then probably not percent-encoded
+func canonicalizeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		if key[i] == '%' && i+2 < len(key) && isHexDigit(key[i+1]) && isHexDigit(key[i+2]) {
+			v, _ := strconv.ParseUint(key[i+1:i+3], 16, 8)
+			b.WriteByte(byte(v))
+			i += 2
+			continue
+		}
+		b.WriteByte(key[i])
+	}
+	if !utf8.ValidString(b.String()) {
+		return key
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Responds with an S3 error with the code for the first failures requests, and succeeds after that
+type failingTransport struct {
+	mu       sync.Mutex
+	code     string
+	failures int
+	requests int
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests++
+	if t.requests <= t.failures {
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Header:     http.Header{"Content-Type": {"application/xml"}},
+			Body:       io.NopCloser(strings.NewReader("<Error><Code>" + t.code + "</Code><Message>Try again</Message></Error>")),
+			Request:    req,
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/xml"}},
+		Body:       io.NopCloser(strings.NewReader("<Tagging><TagSet></TagSet></Tagging>")),
+		Request:    req,
+	}, nil
+}
+
+func getObjectTaggingWithRetryer(transport *failingTransport, retryer func() aws.Retryer) error {
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String("http://127.0.0.1"),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   &http.Client{Transport: transport},
+		Retryer:      retryer(),
+	})
+	_, err := client.GetObjectTagging(context.Background(), &s3.GetObjectTaggingInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("key"),
+	})
+	return err
+}
+
+func TestRetryableErrors(t *testing.T) {
+	for _, retryMode := range []string{"standard", "adaptive"} {
+		transport := &failingTransport{code: "NotReadyYet", failures: 1}
+		err := getObjectTaggingWithRetryer(transport, newRetryer(retryMode, 0, []string{"SomethingElse", "NotReadyYet"}))
+		if err != nil {
+			t.Fatalf("%s: expected the error to be retried, got: %v", retryMode, err)
+		}
+		if transport.requests != 2 {
+			t.Fatalf("%s: expected 2 requests, got %d", retryMode, transport.requests)
+		}
+	}
+}
+
+func TestRetryableErrorsOtherCode(t *testing.T) {
+	transport := &failingTransport{code: "NotReadyYet", failures: 1}
+	err := getObjectTaggingWithRetryer(transport, newRetryer("standard", 0, []string{"SomethingElse"}))
+	if err == nil || !strings.Contains(err.Error(), "NotReadyYet") {
+		t.Fatalf("expected the NotReadyYet error, got: %v", err)
+	}
+	if transport.requests != 1 {
+		t.Fatalf("expected the error to not be retried, got %d requests", transport.requests)
+	}
+}
+
+func TestParseFilesize(t *testing.T) {
+	tests := map[string]uint64{
+		"1073741824":           1073741824,
+		"512MiB":               512 * MiB,
+		"1GB":                  GiB,
+		"1g":                   GiB,
+		" 2TiB ":               2 * TiB,
+		"16777215TiB":          16777215 * TiB,
+		"18446744073709551615": math.MaxUint64,
+	}
+	for s, expected := range tests {
+		n, err := parseFilesize(s)
+		if err != nil || n != expected {
+			t.Errorf("parseFilesize(%q) = %d, %v, expected %d", s, n, err, expected)
+		}
+	}
+	// Sizes that do not fit in 64 bits must not wrap around to a small size
+	for _, s := range []string{"16777216TiB", "18446744073709551615k", "18446744073709551616", "1PiB", "abc", "MiB"} {
+		if n, err := parseFilesize(s); err == nil {
+			t.Errorf("parseFilesize(%q) = %d, expected an error", s, n)
+		}
+	}
+}
+
+func TestSyncAndClose(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "sums.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("line\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncAndClose(f); err != nil {
+		t.Fatal(err)
+	}
+	// The second close fails, which must be reported
+	if err := syncAndClose(f); err == nil {
+		t.Fatal("expected an error for a file that is already closed")
+	}
+
+	// A pipe (e.g. --append-to /dev/stdout) can not be synced, which is not an error
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := syncAndClose(w); err != nil {
+		t.Fatalf("expected a pipe to be closed without an error, got: %v", err)
+	}
+}
@@ -0,0 +1,2160 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/minio/sha256-simd"
+	flag "github.com/stefansundin/go-zflag"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// The object that a worker is hashing, which the goroutines that print the status read while the object is hashed
+type hashStatus struct {
+	copying atomic.Bool
+	hashed  hashPosition
+	// Held while writing to the hashes, so that their state can be marshaled by printStatus
+	hashMu sync.Mutex
+	// Prints the position and the command to resume hashing from it, set before copying is set
+	printStatus func(position uint64)
+
+	// Cancels the download of the object when --interrupt-skips is used, cancelMu guards it and the S3Uri of the object
+	cancelMu     sync.Mutex
+	cancelObject func()
+	arg          string
+}
+
+// The status of each worker, the signal handler is started before the workers are created
+type workerStatuses struct {
+	mu       sync.Mutex
+	statuses []*hashStatus
+}
+
+func (w *workerStatuses) add() *hashStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := &hashStatus{cancelObject: func() {}}
+	w.statuses = append(w.statuses, s)
+	return s
+}
+
+// Returns the workers that are currently hashing an object
+func (w *workerStatuses) hashing() []*hashStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var hashing []*hashStatus
+	for _, s := range w.statuses {
+		if s.copying.Load() {
+			hashing = append(hashing, s)
+		}
+	}
+	return hashing
+}
+
+// Cancels the download of the object that is being hashed, and returns its S3Uri
+func (s *hashStatus) skip() string {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	s.cancelObject()
+	return s.arg
+}
+
+// The regions of the buckets are looked up once and shared by the workers
+type bucketRegions struct {
+	mu      sync.Mutex
+	regions map[string]string
+}
+
+func (b *bucketRegions) get(bucket string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.regions[bucket]
+}
+
+func (b *bucketRegions) set(bucket, region string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.regions[bucket] = region
+}
+
+// Prints the output of each object with --concurrency, once the output of the objects before it has been printed
+// The output is in the order of the S3Uris, regardless of the order that the workers finish the objects in
+type orderedOutput struct {
+	mu      sync.Mutex
+	w       io.Writer
+	next    int
+	pending map[int][]byte
+}
+
+func newOrderedOutput(w io.Writer) *orderedOutput {
+	return &orderedOutput{w: w, pending: make(map[int][]byte)}
+}
+
+func (o *orderedOutput) done(i int, output []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending[i] = output
+	for {
+		output, ok := o.pending[o.next]
+		if !ok {
+			return
+		}
+		o.w.Write(output)
+		delete(o.pending, o.next)
+		o.next++
+	}
+}
+
+// Prints the output of an object right away, when the program is about to stop because of it
+func (o *orderedOutput) flush(output []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w.Write(output)
+}
+
+// The options, settings and helpers that main sets up for the workers, and the results of the objects that the workers share
+// The workers are created with newWorker after the setup is done, none of the fields except the results change after that
+type hashRun struct {
+	// Command line options
+	watchInterval           time.Duration
+	paranoidInterval        time.Duration
+	waitTimeout             time.Duration
+	verifyRetries           int
+	maxRetries              int
+	maxConcurrentParts      int
+	truncate                int
+	outputMode              string
+	restoreTier             string
+	recordToDynamoDB        string
+	dynamoDBTable           string
+	checksumHeader          string
+	sinceLastRun            string
+	manifest                string
+	compareLocal            string
+	progressURL             string
+	preHashCommand          string
+	postHashCommand         string
+	hookFailure             string
+	hmacKey                 string
+	outputDir               string
+	resume                  string
+	versionId               string
+	expectedBucketOwner     string
+	requestPayer            string
+	outputFormat            string
+	storageClasses          []string
+	algorithms              []string
+	progress                bool
+	writeTag                bool
+	writeMetadata           bool
+	force                   bool
+	assumeYes               bool
+	tag                     bool
+	compact                 bool
+	copyResume              bool
+	concat                  bool
+	decompress              bool
+	normalizeCRLF           bool
+	checksumMode            bool
+	resumeQR                bool
+	useAccelerateEndpoint   bool
+	verifyOnly              bool
+	headChecksum            bool
+	listChecksums           bool
+	printPresigned          bool
+	onlyMissing             bool
+	headOnly                bool
+	checksumOnly            bool
+	verifyETagOnly          bool
+	kmsDecryptCheck         bool
+	clientSideDecrypt       bool
+	verifyContentMD5        bool
+	reconstructETag         bool
+	printParts              bool
+	sidecar                 bool
+	verifyParallelHashes    bool
+	fullFingerprintFlag     bool
+	strictMetadata          bool
+	detectDuplicates        bool
+	merkle                  bool
+	outputOnMismatchOnly    bool
+	verifyObjectLock        bool
+	requireLock             bool
+	diff                    bool
+	failOnAlgorithmMismatch bool
+	verifyAndRestore        bool
+	waitForObject           bool
+	failFast                bool
+	decodeKey               bool
+	canonicalizeKeys        bool
+	debug                   bool
+	verbose                 bool
+	quiet                   bool
+
+	// Set up by main from the options
+	units                unitSystem
+	syslogOutput         *syslogWriter
+	endpointURL          string
+	bucketEndpoints      map[string]string
+	sseCustomerKeyBase64 string
+	sseCustomerKeyMD5    string
+	outputTemplate       *template.Template
+	args                 []string
+	checkSums            []string
+	sampleBytes          uint64
+	checkpointBytes      uint64
+	fromByteOffset       uint64
+	composite            bool
+	partSizeBytes        uint64
+	maxMemoryBytes       uint64
+	bandwidthLimiter     *rateLimiter
+	bandwidthPerPart     uint64
+	resumable            bool
+	etagManifest         map[string]string
+	runJournal           *journal
+	localSize            int64
+	localPath            string
+	localFingerprint     string
+	cache                checksumCache
+	appendFile           *os.File
+	h                    hash.Hash
+	hashes               []hash.Hash
+	position             uint64
+	concatIndex          int
+	concatStart          uint64
+	workers              *workerStatuses
+	ctx                  context.Context
+	metrics              *jobMetrics
+	cfg                  aws.Config
+	dynamoDBClient       *dynamodb.Client
+	recorder             *dynamoDBRecorder
+	bucketLocations      *bucketRegions
+	inventoryObjects     map[string]inventoryObject
+	report               *htmlReport
+
+	// Helpers defined in main
+	saveCache         func()
+	outputFile        func(bucket string, algorithm string) (*os.File, error)
+	closeOutputFiles  func()
+	shutdownTracing   func()
+	flushRecords      func()
+	printObjectError  func(err error, bucket string, key string)
+	retryThrottled    func(fn func() error) error
+	newRegionalClient func(bucket string, bucketRegion string) *s3.Client
+	bucketClient      func(bucket string) (*s3.Client, error)
+	writeReport       func()
+	label             func(algorithm string) string
+
+	// The results of the objects, resultsMu guards them
+	resultsMu *sync.Mutex
+	// The state of the objects for the next --since-last-run, saved by saveCache
+	lastRun runState
+	// The buckets that Transfer Acceleration has been checked for
+	accelerateChecked map[string]bool
+	// The objects that could not be hashed
+	failures int
+	// The S3Uris of each sum, for --detect-duplicates
+	duplicates   map[string][]string
+	merkleLeaves []merkleLeaf
+	// The objects to hash again with --watch
+	watched []watchedObject
+	// verificationFailed is reset for each object to know whether the object failed, and remembered in anyVerificationFailed
+	anyVerificationFailed bool
+	// The objects that matched the sum in the --check file
+	checkedOK int
+	// The objects that have been downloaded (or sampled), counted for --max-objects
+	downloadedObjects int
+}
+
+// Each worker hashes one object at a time with its own hashes and the state of the object, the clients and the results are shared
+// With --concurrency the output of each object is buffered and given to output, which prints it in the order of the S3Uris
+func (r *hashRun) newWorker(output *orderedOutput) func(i int, uri string) {
+	worker := r.workers.add()
+	// A resumed hash state is only continued by the single worker that --resume can be used with
+	h, hashes := r.h, r.hashes
+	newHashes := func() {
+		hashes = nil
+		for _, algorithm := range r.algorithms {
+			if r.hmacKey != "" {
+				hashes = append(hashes, hmac.New(func() hash.Hash {
+					hh, _ := newHash(algorithm)
+					return hh
+				}, []byte(r.hmacKey)))
+				continue
+			}
+			hh, _ := newHash(algorithm)
+			hashes = append(hashes, hh)
+		}
+		if r.hmacKey != "" {
+			h = &lengthCounter{}
+		} else {
+			h = hashes[0]
+		}
+	}
+	if r.resume == "" && r.concat {
+		newHashes()
+	}
+
+	// Print the current position and the command to resume hashing from it
+	var arg string
+	var obj *s3.GetObjectOutput
+	var objLength uint64
+	var copyStartTime time.Time
+	var copyStartPosition uint64
+	encodeResumeState := func(state []byte) string {
+		encodedState := encodeHashState(state)
+		if r.concat {
+			return formatConcatResumeState(r.concatIndex, r.concatStart, encodedState)
+		}
+		return encodedState
+	}
+	resumeArgs := func() []string {
+		if r.concat {
+			return flag.Args()
+		}
+		return []string{arg}
+	}
+	printResumeStatus := func(position uint64) {
+		var remaining uint64
+		if objLength > position {
+			remaining = objLength - position
+		}
+		throughput := formatThroughput(position-copyStartPosition, time.Since(copyStartTime), remaining, r.units)
+		if !r.resumable {
+			status := fmt.Sprintf("Hashed %s.", formatProgress(position, objLength, r.units))
+			if r.decompress {
+				status = fmt.Sprintf("Hashed %s of decompressed data.", formatFilesize(position, r.units))
+			}
+			fmt.Fprintln(stderr, strings.TrimSpace(status+" "+throughput))
+			return
+		}
+		worker.hashMu.Lock()
+		state, err := hashMarshalBinary(h)
+		worker.hashMu.Unlock()
+		if err != nil {
+			fmt.Fprintf(stderr, "Error marshaling the resume state: %v\n", err)
+			os.Exit(1)
+		}
+		if state == nil {
+			return
+		}
+		if throughput != "" {
+			fmt.Fprintln(stderr, throughput)
+		}
+		fmt.Fprintf(stderr, "To resume hashing from %s, run: %s\n", formatProgress(position, objLength, r.units), formatResumeCommand(encodeResumeState(state), resumeArgs()...))
+	}
+	worker.printStatus = printResumeStatus
+
+	// Print the command (and optionally a QR code) to resume after the program has stopped
+	printResumeHelp := func() {
+		state, err := hashMarshalBinary(h)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error marshaling the resume state: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(stderr, "To resume hashing from this position, run:")
+		fmt.Fprintln(stderr, formatResumeCommand(encodeResumeState(state), resumeArgs()...))
+		fmt.Fprintln(stderr)
+		if r.resumeQR {
+			qr, err := renderQRCode(encodeResumeState(state))
+			if err != nil {
+				fmt.Fprintf(stderr, "Error rendering the QR code: %v\n", err)
+			} else {
+				fmt.Fprintln(stderr, "The resume state as a QR code (pass the scanned value to --resume):")
+				fmt.Fprint(stderr, qr)
+				fmt.Fprintln(stderr)
+			}
+		}
+		if r.copyResume {
+			err := writeClipboard(encodeResumeState(state))
+			if err != nil {
+				fmt.Fprintf(stderr, "Error copying the resume state to the clipboard: %v\n", err)
+			} else {
+				fmt.Fprintln(stderr, "The resume state has been copied to the clipboard (resume with --resume-clipboard).")
+				fmt.Fprintln(stderr)
+			}
+		}
+		fmt.Fprintln(stderr, "Note: This value is the internal state of the hash function. It may not be compatible across versions of s3sha256sum or across Go versions.")
+	}
+
+	// If paranoid, start the go routine that runs in the background
+	// This feels a bit unsafe but haven't had any problems in my testing
+	if r.paranoidInterval != 0 {
+		go func() {
+			lastPosition := r.position
+			for {
+				time.Sleep(r.paranoidInterval)
+				if !worker.copying.Load() {
+					continue
+				}
+				position := worker.hashed.n.Load()
+				if position == 0 || position == lastPosition {
+					continue
+				}
+				lastPosition = position
+				printResumeStatus(position)
+				if r.progressURL != "" {
+					bucket, key, _ := parseS3Uri(arg)
+					err := postProgress(context.Background(), r.progressURL, progressUpdate{
+						URI:         fmt.Sprintf("s3://%s/%s", bucket, key),
+						BytesHashed: position,
+						Total:       objLength,
+					})
+					if err != nil && !r.quiet {
+						fmt.Fprintf(stderr, "Warning: Unable to post the progress to --progress-url: %v\n", err)
+					}
+				}
+			}
+		}()
+	}
+
+	// The progress line is updated in the background while an object is downloaded, and cleared before its sum is printed
+	// It is only redrawn in place when stderr is not also sent to the system log or formatted as JSON
+	var progressBar *progressLine
+	if r.progress {
+		progressBar = newProgressLine(stderr, stderr == io.Writer(os.Stderr) && isTerminal(os.Stderr), r.units)
+		go func() {
+			for {
+				time.Sleep(progressBar.interval())
+				progressBar.update(worker.hashed.n.Load())
+			}
+		}()
+	}
+
+	var out io.Writer
+	var buf *bytes.Buffer
+	var jsonOutput *json.Encoder
+	var currentSpan *objectSpan
+	var reportObj *reportObject
+	verificationFailed := false
+
+	// The record is printed when the object is done, or right away when it fails
+	writeRecord := func() {
+		if jsonOutput == nil || reportObj == nil {
+			return
+		}
+		if err := jsonOutput.Encode(reportObj); err != nil {
+			fmt.Fprintf(stderr, "Error: Unable to write the output: %v\n", err)
+			os.Exit(1)
+		}
+		reportObj = nil
+	}
+	objectFailed := func() {
+		if r.metrics != nil {
+			r.metrics.failures.Add(1)
+		}
+		currentSpan.end("error")
+		if reportObj != nil {
+			reportObj.Result = reportError
+		}
+		writeRecord()
+		if r.checkSums != nil {
+			fmt.Fprintln(out, formatCheckLine(arg, "FAILED open or read"))
+		}
+		if r.failFast || r.concat || r.ctx.Err() != nil {
+			if output != nil {
+				output.flush(buf.Bytes())
+			}
+			r.flushRecords()
+			r.closeOutputFiles()
+			r.shutdownTracing()
+			r.writeReport()
+			os.Exit(1)
+		}
+		r.resultsMu.Lock()
+		r.failures++
+		r.resultsMu.Unlock()
+	}
+
+	// Prints why an object is skipped, with --check the object was not compared with its sum so it counts as FAILED
+	skipObject := func(message, reason string) {
+		currentSpan.end("skipped")
+		if r.checkSums != nil {
+			fmt.Fprintln(out, formatCheckLine(arg, "FAILED not checked ("+reason+")"))
+			verificationFailed = true
+			return
+		}
+		fmt.Fprintln(out, message)
+	}
+
+	// Results are separated by blank lines unless --compact is used
+	printSeparator := func() {
+		if !r.compact {
+			fmt.Fprintln(out)
+		}
+	}
+
+	// Compares the sums of the object with the --compare-local file, which is only hashed if its sums are not cached
+	// Returns false if any of the sums did not match
+	compareLocalSums := func(sums map[string]string) (bool, error) {
+		localSums := r.cache.lookup(r.localPath, r.localFingerprint, r.algorithms)
+		if localSums == nil {
+			var err error
+			localSums, err = hashLocalFile(r.compareLocal, r.algorithms)
+			if err != nil {
+				return false, err
+			}
+			if r.cache != nil {
+				r.cache.store(r.localPath, r.localFingerprint, localSums)
+				r.saveCache()
+			}
+		}
+		matched := true
+		for _, algorithm := range r.algorithms {
+			if localSums[algorithm] == sums[algorithm] {
+				fmt.Fprintf(out, "%sOK (matches the local file %s)\n", r.label(algorithm), r.compareLocal)
+			} else {
+				fmt.Fprintf(out, "%sFAILED (did not match the local file %s)\n", r.label(algorithm), r.compareLocal)
+				fmt.Fprintf(out, "Local:    %s\n", localSums[algorithm])
+				verificationFailed = true
+				matched = false
+			}
+		}
+		return matched, nil
+	}
+
+	// The span is ended when the object is done, unless it was already ended by an error
+	// A verification that failed after the object was hashed (e.g. --compare-local) also fails the object in the report
+	finishObject := func() {
+		if verificationFailed {
+			currentSpan.end("FAILED")
+			if reportObj != nil && reportObj.Result != reportError {
+				reportObj.Result = reportFailed
+			}
+		} else {
+			currentSpan.end("OK")
+		}
+		writeRecord()
+	}
+
+	return func(i int, uri string) {
+		arg = uri
+		var w io.Writer = os.Stdout
+		if output != nil {
+			buf = &bytes.Buffer{}
+			w = buf
+		}
+		out, jsonOutput = w, nil
+		if r.outputMode == "json" {
+			out, jsonOutput = io.Discard, json.NewEncoder(w)
+		}
+		verificationFailed = false
+		reportObj = nil
+		defer func() {
+			finishObject()
+			r.resultsMu.Lock()
+			r.anyVerificationFailed = r.anyVerificationFailed || verificationFailed
+			r.resultsMu.Unlock()
+			if output != nil {
+				output.done(i, buf.Bytes())
+			}
+		}()
+		spanCtx := r.ctx
+		var err error
+
+		if i != 0 && !r.concat && !r.checksumOnly && !r.verifyETagOnly && !r.kmsDecryptCheck && r.manifest == "" && r.checkSums == nil {
+			printSeparator()
+		}
+
+		// The byte offset in this object to start hashing from
+		offset := r.position
+		if r.fromByteOffset != 0 {
+			offset = r.fromByteOffset
+		}
+		if r.concat {
+			if i < r.concatIndex {
+				return
+			} else if i == r.concatIndex {
+				offset = r.position - r.concatStart
+			} else {
+				offset = 0
+			}
+			r.concatIndex = i
+			r.concatStart = hashGetLen(h) - offset
+		}
+
+		// A versionId in the S3Uri takes precedence over --version-id
+		bucket, key, objVersionId := parseS3Uri(arg)
+		if r.decodeKey {
+			decodedKey, err := url.PathUnescape(key)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: The key %q is not URL encoded correctly (a %% that is part of the key must be encoded as %%25): %v\n", key, err)
+				objectFailed()
+				return
+			}
+			key = decodedKey
+		}
+		// The warning shows what the key was changed to, so that a key that was encoded by mistake can be spotted
+		if r.canonicalizeKeys {
+			if canonicalKey := canonicalizeKey(key); canonicalKey != key {
+				if !r.quiet {
+					fmt.Fprintf(stderr, "Warning: The key %q is not in its canonical form. Using the key %q.\n", key, canonicalKey)
+				}
+				key = canonicalKey
+			}
+		}
+		spanCtx, currentSpan = startObjectSpan(r.ctx, bucket, key)
+		if r.report != nil || jsonOutput != nil {
+			reportObj = newReportObject(arg, bucket, key)
+			r.resultsMu.Lock()
+			r.report.add(reportObj)
+			r.resultsMu.Unlock()
+		}
+		if objVersionId == "" {
+			objVersionId = r.versionId
+		}
+		// "latest" is the same as not specifying a version
+		if objVersionId == "latest" {
+			objVersionId = ""
+		}
+		stateURI := fmt.Sprintf("s3://%s/%s", bucket, key)
+		if objVersionId != "" {
+			stateURI += "?versionId=" + objVersionId
+		}
+		if reportObj != nil {
+			reportObj.URI = stateURI
+			reportObj.VersionId = objVersionId
+		}
+
+		// Only the printed sums are truncated, the sums are compared in full
+		printedSum := func(sum string) string {
+			if r.truncate > 0 {
+				return sum[:r.truncate]
+			}
+			return sum
+		}
+
+		// Skip objects that were hashed before the run was stopped, the object was compared with its stored sums then
+		if r.runJournal != nil {
+			if e, ok := r.runJournal.lookup(stateURI, r.algorithms); ok {
+				if r.checkSums != nil {
+					fmt.Fprintln(out, formatCheckLine(arg, "FAILED not checked (skipped, it is in the --journal)"))
+					verificationFailed = true
+					return
+				}
+				result := "it was OK"
+				if e.Failed {
+					result = "it FAILED"
+					verificationFailed = true
+				}
+				for _, algorithm := range r.algorithms {
+					fmt.Fprintf(out, "%s%s  %s (from the --journal, %s)\n", r.label(algorithm), printedSum(e.Sums[algorithm]), stateURI, result)
+				}
+				return
+			}
+		}
+
+		// Create an S3 client for the region
+		var regionalClient *s3.Client
+		regionalClient, err = r.bucketClient(bucket)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error getting bucket region: %v\n", err)
+			fmt.Fprintln(stderr, "Try adding --region.")
+			objectFailed()
+			return
+		}
+
+		// If the bucket is in a different region than the one used, S3 responds with the correct region in a header
+		// Switch to that region and try again
+		followRedirect := func(err error) bool {
+			if r.endpointURL != "" || r.bucketEndpoints[bucket] != "" {
+				return false
+			}
+			bucketRegion := getBucketRegionFromError(err)
+			if bucketRegion == "" || bucketRegion == regionalClient.Options().Region {
+				return false
+			}
+			if r.verbose {
+				fmt.Fprintf(stderr, "The bucket %s is in %s. Retrying in that region.\n", bucket, bucketRegion)
+			}
+			r.bucketLocations.set(bucket, bucketRegion)
+			regionalClient = r.newRegionalClient(bucket, bucketRegion)
+			return true
+		}
+
+		// Requests fail with a confusing error if Transfer Acceleration is not enabled on the bucket
+		// The configuration can not be read through the accelerate endpoint
+		r.resultsMu.Lock()
+		checkAccelerate := r.useAccelerateEndpoint && !r.accelerateChecked[bucket]
+		r.accelerateChecked[bucket] = true
+		r.resultsMu.Unlock()
+		if checkAccelerate {
+			accelerateInput := &s3.GetBucketAccelerateConfigurationInput{
+				Bucket: aws.String(bucket),
+			}
+			if r.expectedBucketOwner != "" {
+				accelerateInput.ExpectedBucketOwner = aws.String(r.expectedBucketOwner)
+			}
+			accelerateConfig, err := regionalClient.GetBucketAccelerateConfiguration(r.ctx, accelerateInput, func(o *s3.Options) {
+				o.UseAccelerate = false
+			})
+			if err != nil {
+				if r.verbose {
+					fmt.Fprintf(stderr, "Was not able to check if Transfer Acceleration is enabled on the bucket %s: %v\n", bucket, err)
+				}
+			} else if accelerateConfig.Status != s3Types.BucketAccelerateStatusEnabled && !r.quiet {
+				fmt.Fprintf(stderr, "Warning: Transfer Acceleration is not enabled on the bucket %s, so --use-accelerate-endpoint will likely fail.\n", bucket)
+			}
+		}
+
+		getObjectTaggingInput := &s3.GetObjectTaggingInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		if objVersionId != "" {
+			getObjectTaggingInput.VersionId = aws.String(objVersionId)
+		}
+		if r.expectedBucketOwner != "" {
+			getObjectTaggingInput.ExpectedBucketOwner = aws.String(r.expectedBucketOwner)
+		}
+		if r.requestPayer != "" {
+			getObjectTaggingInput.RequestPayer = s3Types.RequestPayer(r.requestPayer)
+		}
+
+		headObjectInput := &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		if objVersionId != "" {
+			headObjectInput.VersionId = aws.String(objVersionId)
+		}
+		if r.expectedBucketOwner != "" {
+			headObjectInput.ExpectedBucketOwner = aws.String(r.expectedBucketOwner)
+		}
+		if r.requestPayer != "" {
+			headObjectInput.RequestPayer = s3Types.RequestPayer(r.requestPayer)
+		}
+		if r.sseCustomerKeyBase64 != "" {
+			headObjectInput.SSECustomerAlgorithm = aws.String("AES256")
+			headObjectInput.SSECustomerKey = aws.String(r.sseCustomerKeyBase64)
+			headObjectInput.SSECustomerKeyMD5 = aws.String(r.sseCustomerKeyMD5)
+		}
+		if r.headOnly || r.listChecksums {
+			headObjectInput.ChecksumMode = s3Types.ChecksumModeEnabled
+		}
+
+		// The URL is signed with the same client (and region) that would download the object
+		// Headers that are signed (e.g. for SSE-C) must be sent with the request as well
+		if r.printPresigned {
+			presigned, err := s3.NewPresignClient(regionalClient).PresignGetObject(r.ctx, &s3.GetObjectInput{
+				Bucket:               aws.String(bucket),
+				Key:                  aws.String(key),
+				VersionId:            headObjectInput.VersionId,
+				ExpectedBucketOwner:  headObjectInput.ExpectedBucketOwner,
+				RequestPayer:         headObjectInput.RequestPayer,
+				SSECustomerAlgorithm: headObjectInput.SSECustomerAlgorithm,
+				SSECustomerKey:       headObjectInput.SSECustomerKey,
+				SSECustomerKeyMD5:    headObjectInput.SSECustomerKeyMD5,
+			})
+			if err != nil {
+				r.printObjectError(err, bucket, key)
+				objectFailed()
+				return
+			}
+			fmt.Fprintln(out, presigned.URL)
+			var names []string
+			for name := range presigned.SignedHeader {
+				if !strings.EqualFold(name, "Host") {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				for _, value := range presigned.SignedHeader[name] {
+					fmt.Fprintf(out, "Signed header: %s: %s\n", name, value)
+				}
+			}
+			return
+		}
+
+		// Poll until the object exists, backing off between the attempts
+		if r.waitForObject {
+			waitStart := time.Now()
+			timedOut := false
+			for attempt := 1; ; attempt++ {
+				_, err = regionalClient.HeadObject(r.ctx, headObjectInput)
+				if err != nil && followRedirect(err) {
+					_, err = regionalClient.HeadObject(r.ctx, headObjectInput)
+				}
+				if err == nil || !isNotFoundError(err) {
+					break
+				}
+				delay := retryDelay(attempt)
+				if r.waitTimeout != 0 {
+					remaining := r.waitTimeout - time.Since(waitStart)
+					if remaining <= 0 {
+						timedOut = true
+						break
+					}
+					delay = min(delay, remaining)
+				}
+				if r.verbose {
+					fmt.Fprintf(stderr, "The object s3://%s/%s does not exist yet. Checking again in %s.\n", bucket, key, delay.Round(time.Millisecond))
+				}
+				// objectFailed stops the program when it was interrupted, after the results so far have been written
+				select {
+				case <-r.ctx.Done():
+					objectFailed()
+					return
+				case <-time.After(delay):
+				}
+			}
+			if timedOut {
+				fmt.Fprintf(stderr, "Error: Timed out after %s waiting for s3://%s/%s to exist.\n", r.waitTimeout, bucket, key)
+				objectFailed()
+				return
+			} else if err != nil {
+				r.printObjectError(err, bucket, key)
+				objectFailed()
+				return
+			}
+		}
+
+		// Look up the stored checksums with HeadObject when they are needed before downloading the object
+		var head *s3.HeadObjectOutput
+		if r.verifyOnly || r.headChecksum || r.listChecksums || r.sampleBytes > 0 || r.cache != nil || r.onlyMissing || r.headOnly || r.lastRun != nil || r.etagManifest != nil || len(r.storageClasses) > 0 || r.verifyAndRestore {
+			headObject := func() error {
+				var err error
+				head, err = regionalClient.HeadObject(r.ctx, headObjectInput)
+				return err
+			}
+			err = r.retryThrottled(headObject)
+			if err != nil && followRedirect(err) {
+				err = r.retryThrottled(headObject)
+			}
+			if err != nil {
+				r.printObjectError(err, bucket, key)
+				objectFailed()
+				return
+			}
+		}
+
+		// Print the object information without downloading the object
+		if r.headOnly {
+			printHeadObject(out, bucket, key, regionalClient.Options().Region, head, r.units)
+			return
+		}
+
+		// S3 omits the storage class for objects in the STANDARD storage class
+		if len(r.storageClasses) > 0 {
+			storageClass := string(head.StorageClass)
+			if storageClass == "" {
+				storageClass = string(s3Types.StorageClassStandard)
+			}
+			if !slices.Contains(r.storageClasses, storageClass) {
+				skipObject(fmt.Sprintf("Skipping s3://%s/%s (storage class %s)", bucket, key, storageClass), "skipped, storage class "+storageClass)
+				return
+			}
+		}
+
+		// Skip objects that have not changed since they were last hashed
+		if r.lastRun != nil {
+			r.resultsMu.Lock()
+			e, ok := r.lastRun[stateURI]
+			r.resultsMu.Unlock()
+			if ok && e.ETag == strings.Trim(aws.ToString(head.ETag), `"`) {
+				skipObject(fmt.Sprintf("Skipping %s (unchanged since it was hashed at %s, the sha256 sum was %s)", stateURI, e.HashedAt.Format(time.RFC3339), e.Sum), "skipped by --since-last-run")
+				return
+			}
+		}
+
+		// Skip objects that have the ETag that they had when the manifest was made
+		if r.etagManifest != nil {
+			if etag, ok := r.etagManifest[stateURI]; ok && etag == normalizeETag(aws.ToString(head.ETag)) {
+				skipObject(fmt.Sprintf("Skipping %s (the ETag %s has not changed since the --only-changed-etag manifest)", stateURI, etag), "skipped by --only-changed-etag")
+				return
+			}
+		}
+
+		// Skip objects that already have a stored checksum
+		if r.onlyMissing {
+			storedSum := head.Metadata["sha256sum"]
+			storedSumSource := "metadata"
+			if storedSum == "" {
+				storedSum, err = getObjectTagValue(r.ctx, regionalClient, getObjectTaggingInput, "sha256sum")
+				if err != nil {
+					fmt.Fprintln(stderr, "Was not able to get object tags (looking for 'sha256sum' tag).")
+					fmt.Fprintln(stderr, err)
+					objectFailed()
+					return
+				}
+				storedSumSource = "tag"
+			}
+			if storedSum != "" {
+				skipObject(fmt.Sprintf("Skipping s3://%s/%s (object %s 'sha256sum' already present)", bucket, key, storedSumSource), "skipped by --only-missing")
+				return
+			}
+		}
+
+		// Restore archived objects and poll until the restored copy can be downloaded
+		if r.verifyAndRestore && isArchived(head) {
+			ongoing, restored := parseRestoreStatus(aws.ToString(head.Restore))
+			if !restored && !ongoing {
+				if !r.quiet {
+					fmt.Fprintf(stderr, "Restoring s3://%s/%s from %s with the %s tier.\n", bucket, key, head.StorageClass, r.restoreTier)
+				}
+				err = r.retryThrottled(func() error {
+					return restoreObject(r.ctx, regionalClient, head, headObjectInput, r.restoreTier)
+				})
+				if err != nil {
+					fmt.Fprintf(stderr, "Error: Unable to restore s3://%s/%s: %v\n", bucket, key, err)
+					objectFailed()
+					return
+				}
+			} else if ongoing && !r.quiet {
+				fmt.Fprintf(stderr, "A restore of s3://%s/%s is already in progress.\n", bucket, key)
+			}
+			restoreStart := time.Now()
+			timedOut := false
+			for !restored {
+				delay := restorePollInterval(r.restoreTier)
+				if r.waitTimeout != 0 {
+					remaining := r.waitTimeout - time.Since(restoreStart)
+					if remaining <= 0 {
+						timedOut = true
+						break
+					}
+					delay = min(delay, remaining)
+				}
+				if r.verbose {
+					fmt.Fprintf(stderr, "The object s3://%s/%s is being restored. Checking again in %s.\n", bucket, key, delay)
+				}
+				select {
+				case <-r.ctx.Done():
+					objectFailed()
+					return
+				case <-time.After(delay):
+				}
+				err = r.retryThrottled(func() error {
+					var err error
+					head, err = regionalClient.HeadObject(r.ctx, headObjectInput)
+					return err
+				})
+				if err != nil {
+					break
+				}
+				_, restored = parseRestoreStatus(aws.ToString(head.Restore))
+			}
+			if timedOut {
+				fmt.Fprintf(stderr, "Error: Timed out after %s waiting for s3://%s/%s to be restored. The restore continues, run again later to hash the object.\n", r.waitTimeout, bucket, key)
+				objectFailed()
+				return
+			} else if err != nil {
+				r.printObjectError(err, bucket, key)
+				objectFailed()
+				return
+			}
+			if !r.quiet {
+				fmt.Fprintf(stderr, "The object s3://%s/%s has been restored (the restored copy expires %s).\n", bucket, key, restoreExpiry(aws.ToString(head.Restore)))
+			}
+		}
+
+		// Compare the stored checksums against each other without downloading the object
+		if r.verifyOnly {
+			metadataSum := head.Metadata["sha256sum"]
+			tagSum, err := getObjectTagValue(r.ctx, regionalClient, getObjectTaggingInput, "sha256sum")
+			if err != nil {
+				fmt.Fprintln(stderr, "Was not able to get object tags (looking for 'sha256sum' tag to compare against).")
+				fmt.Fprintln(stderr, err)
+				objectFailed()
+				return
+			}
+
+			fmt.Fprintf(out, "s3://%s/%s\n", bucket, key)
+			if metadataSum == "" && tagSum == "" {
+				fmt.Fprintln(out, "Neither metadata nor tag 'sha256sum' present. Nothing to compare.")
+			} else if metadataSum == "" {
+				fmt.Fprintf(out, "Tag:      %s\n", tagSum)
+				fmt.Fprintln(out, "Metadata 'sha256sum' not present. Nothing to compare the tag against.")
+			} else if tagSum == "" {
+				fmt.Fprintf(out, "Metadata: %s\n", metadataSum)
+				fmt.Fprintln(out, "Tag 'sha256sum' not present. Nothing to compare the metadata against.")
+			} else {
+				fmt.Fprintf(out, "Metadata: %s\n", metadataSum)
+				fmt.Fprintf(out, "Tag:      %s\n", tagSum)
+				if strings.EqualFold(metadataSum, tagSum) {
+					fmt.Fprintln(out, "OK (object metadata and tag match)")
+				} else {
+					fmt.Fprintln(out, "FAILED (object metadata and tag do not match)")
+					verificationFailed = true
+				}
+			}
+			return
+		}
+
+		// Compare the stored sum with the S3 checksum without downloading the object, the tags are only looked up if the metadata is not present
+		if r.headChecksum {
+			stored := storedSum{Source: "Metadata", Sum: head.Metadata["sha256sum"]}
+			if stored.Sum == "" {
+				stored.Source = "Tag"
+				stored.Sum, err = getObjectTagValue(r.ctx, regionalClient, getObjectTaggingInput, "sha256sum")
+				if err != nil {
+					fmt.Fprintln(stderr, "Was not able to get object tags (looking for 'sha256sum' tag).")
+					fmt.Fprintln(stderr, err)
+					objectFailed()
+					return
+				}
+			}
+			var attrs *s3.GetObjectAttributesOutput
+			getAttributes := func() error {
+				var err error
+				attrs, err = regionalClient.GetObjectAttributes(r.ctx, &s3.GetObjectAttributesInput{
+					Bucket:               aws.String(bucket),
+					Key:                  aws.String(key),
+					VersionId:            headObjectInput.VersionId,
+					ExpectedBucketOwner:  headObjectInput.ExpectedBucketOwner,
+					RequestPayer:         headObjectInput.RequestPayer,
+					SSECustomerAlgorithm: headObjectInput.SSECustomerAlgorithm,
+					SSECustomerKey:       headObjectInput.SSECustomerKey,
+					SSECustomerKeyMD5:    headObjectInput.SSECustomerKeyMD5,
+					ObjectAttributes:     []s3Types.ObjectAttributes{s3Types.ObjectAttributesChecksum, s3Types.ObjectAttributesObjectParts},
+					MaxParts:             aws.Int32(1),
+				})
+				return err
+			}
+			err = r.retryThrottled(getAttributes)
+			if err != nil && followRedirect(err) {
+				err = r.retryThrottled(getAttributes)
+			}
+			if err != nil {
+				fmt.Fprintln(stderr, "Was not able to get the object attributes (needed for --head-checksum).")
+				r.printObjectError(err, bucket, key)
+				objectFailed()
+				return
+			}
+			// GetObjectAttributes omits the -<parts> suffix that HeadObject has for composite checksums
+			var checksum string
+			var otherAlgorithms []string
+			if attrs.Checksum != nil {
+				checksum = aws.ToString(attrs.Checksum.ChecksumSHA256)
+				otherAlgorithms = checksumAlgorithms(attrs.Checksum.ChecksumCRC32, attrs.Checksum.ChecksumCRC32C, attrs.Checksum.ChecksumSHA1)
+			}
+			if checksum != "" && attrs.ObjectParts != nil && aws.ToInt32(attrs.ObjectParts.TotalPartsCount) > 0 {
+				checksum = fmt.Sprintf("%s-%d", checksum, aws.ToInt32(attrs.ObjectParts.TotalPartsCount))
+			}
+			native := nativeStoredSum(checksum, otherAlgorithms)
+
+			fmt.Fprintln(out, stateURI)
+			if stored.Sum == "" {
+				fmt.Fprintln(out, "Neither metadata nor tag 'sha256sum' present. Nothing to compare.")
+			} else {
+				fmt.Fprintf(out, "%-12s %s\n", stored.Source+":", stored.Sum)
+				if native.Note != "" {
+					fmt.Fprintf(out, "%-12s NOT COMPARED (%s)\n", native.Source+":", native.Note)
+				} else if native.Sum == "" {
+					fmt.Fprintln(out, "S3 checksum not present. Nothing to compare the stored sum against.")
+				} else {
+					fmt.Fprintf(out, "%-12s %s\n", native.Source+":", native.Sum)
+					if strings.EqualFold(stored.Sum, native.Sum) {
+						fmt.Fprintf(out, "OK (object %s and S3 checksum match)\n", strings.ToLower(stored.Source))
+					} else {
+						fmt.Fprintf(out, "FAILED (object %s and S3 checksum do not match)\n", strings.ToLower(stored.Source))
+						verificationFailed = true
+					}
+				}
+			}
+			return
+		}
+
+		// Print the first stored sum that is present, the tags are only looked up if the metadata is not present
+		if r.listChecksums {
+			stored := []storedSum{{Source: "Metadata", Sum: head.Metadata["sha256sum"]}}
+			if stored[0].Sum == "" {
+				tagSum, err := getObjectTagValue(r.ctx, regionalClient, getObjectTaggingInput, "sha256sum")
+				if err != nil {
+					fmt.Fprintln(stderr, "Was not able to get object tags (looking for 'sha256sum' tag).")
+					fmt.Fprintln(stderr, err)
+					objectFailed()
+					return
+				}
+				stored = append(stored, storedSum{Source: "Tag", Sum: tagSum})
+			}
+			stored = append(stored, nativeStoredSum(aws.ToString(head.ChecksumSHA256), checksumAlgorithms(head.ChecksumCRC32, head.ChecksumCRC32C, head.ChecksumSHA1)))
+			printStoredSumLine(out, stateURI, stored)
+			return
+		}
+
+		// The object is not downloaded if its sums are cached for the same ETag
+		if r.cache != nil {
+			if sums := r.cache.lookup(stateURI, strings.Trim(aws.ToString(head.ETag), `"`), r.algorithms); sums != nil {
+				for _, algorithm := range r.algorithms {
+					fmt.Fprintf(out, "%s%s  %s (from the --checksum-cache, the ETag has not changed)\n", r.label(algorithm), printedSum(sums[algorithm]), stateURI)
+				}
+				if _, err := compareLocalSums(sums); err != nil {
+					fmt.Fprintf(stderr, "Error: Unable to hash the --compare-local file: %v\n", err)
+					objectFailed()
+				}
+				return
+			}
+		}
+
+		// Hash the first and last bytes, the requests are pinned to the version and ETag of the HeadObject response
+		// Objects that are not larger than the two samples are hashed in full, so the sum is their sha256 sum
+		if r.sampleBytes > 0 {
+			r.resultsMu.Lock()
+			r.downloadedObjects++
+			r.resultsMu.Unlock()
+			size := uint64(aws.ToInt64(head.ContentLength))
+			ranges := []string{""}
+			if size > 2*r.sampleBytes {
+				ranges = []string{fmt.Sprintf("bytes=0-%d", r.sampleBytes-1), fmt.Sprintf("bytes=%d-%d", size-r.sampleBytes, size-1)}
+			}
+			sampleHash := sha256.New()
+			for _, rng := range ranges {
+				sampleInput := &s3.GetObjectInput{
+					Bucket:               aws.String(bucket),
+					Key:                  aws.String(key),
+					VersionId:            head.VersionId,
+					IfMatch:              head.ETag,
+					ExpectedBucketOwner:  headObjectInput.ExpectedBucketOwner,
+					RequestPayer:         headObjectInput.RequestPayer,
+					SSECustomerAlgorithm: headObjectInput.SSECustomerAlgorithm,
+					SSECustomerKey:       headObjectInput.SSECustomerKey,
+					SSECustomerKeyMD5:    headObjectInput.SSECustomerKeyMD5,
+				}
+				if rng != "" {
+					sampleInput.Range = aws.String(rng)
+				}
+				var sampleObj *s3.GetObjectOutput
+				err = r.retryThrottled(func() error {
+					var err error
+					sampleObj, err = regionalClient.GetObject(r.ctx, sampleInput)
+					return err
+				})
+				if err == nil {
+					_, err = io.Copy(sampleHash, sampleObj.Body)
+					sampleObj.Body.Close()
+				}
+				if err != nil {
+					break
+				}
+			}
+			if err != nil {
+				r.printObjectError(err, bucket, key)
+				objectFailed()
+				return
+			}
+			if len(ranges) == 1 {
+				fmt.Fprintf(out, "%s  %s (sample, the whole object since it is not larger than two samples of %s)\n", printedSum(hex.EncodeToString(sampleHash.Sum(nil))), stateURI, formatFilesize(r.sampleBytes, r.units))
+			} else {
+				fmt.Fprintf(out, "%s  %s (sample of the first and last %s, not a checksum of the object)\n", printedSum(hex.EncodeToString(sampleHash.Sum(nil))), stateURI, formatFilesize(r.sampleBytes, r.units))
+			}
+			return
+		}
+
+		// Look up the part boundaries so that the parts can be hashed individually
+		var parts *objectParts
+		if r.reconstructETag {
+			getObjectAttributesInput := &s3.GetObjectAttributesInput{
+				Bucket:               aws.String(bucket),
+				Key:                  aws.String(key),
+				VersionId:            headObjectInput.VersionId,
+				ExpectedBucketOwner:  headObjectInput.ExpectedBucketOwner,
+				RequestPayer:         headObjectInput.RequestPayer,
+				SSECustomerAlgorithm: headObjectInput.SSECustomerAlgorithm,
+				SSECustomerKey:       headObjectInput.SSECustomerKey,
+				SSECustomerKeyMD5:    headObjectInput.SSECustomerKeyMD5,
+			}
+			getParts := func() error {
+				var err error
+				parts, err = getObjectParts(r.ctx, regionalClient, getObjectAttributesInput, headObjectInput)
+				return err
+			}
+			err = r.retryThrottled(getParts)
+			if err != nil && followRedirect(err) {
+				err = r.retryThrottled(getParts)
+			}
+			if err != nil {
+				fmt.Fprintln(stderr, "Was not able to get the object parts (needed for --reconstruct-etag).")
+				r.printObjectError(err, bucket, key)
+				objectFailed()
+				return
+			}
+			if r.verbose && parts.TotalCount > 0 {
+				fmt.Fprintf(stderr, "The object has %d parts.\n", parts.TotalCount)
+			}
+		}
+
+		runHashHook := func(name, command string, env map[string]string) {
+			env["S3SHA256_URI"] = fmt.Sprintf("s3://%s/%s", bucket, key)
+			if _, ok := env["S3SHA256_VERSION_ID"]; !ok {
+				env["S3SHA256_VERSION_ID"] = objVersionId
+			}
+			err := runHook(command, env)
+			if err == nil {
+				return
+			}
+			if r.hookFailure == "abort" {
+				fmt.Fprintf(stderr, "Error: The %s failed for s3://%s/%s: %v\n", name, bucket, key, err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(stderr, "Warning: The %s failed for s3://%s/%s: %v\n", name, bucket, key, err)
+		}
+		if r.preHashCommand != "" {
+			runHashHook("--pre-hash-command", r.preHashCommand, map[string]string{})
+		}
+
+		// Get the object
+		if r.verbose {
+			fmt.Fprintf(stderr, "Getting s3://%s/%s", bucket, key)
+			if objRegion := regionalClient.Options().Region; objRegion != "" {
+				fmt.Fprintf(stderr, " from %s", objRegion)
+			}
+			fmt.Fprintln(stderr)
+		}
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		if objVersionId != "" {
+			input.VersionId = aws.String(objVersionId)
+		}
+		if r.expectedBucketOwner != "" {
+			input.ExpectedBucketOwner = aws.String(r.expectedBucketOwner)
+		}
+		if r.requestPayer != "" {
+			input.RequestPayer = s3Types.RequestPayer(r.requestPayer)
+		}
+		if r.sseCustomerKeyBase64 != "" {
+			input.SSECustomerAlgorithm = aws.String("AES256")
+			input.SSECustomerKey = aws.String(r.sseCustomerKeyBase64)
+			input.SSECustomerKeyMD5 = aws.String(r.sseCustomerKeyMD5)
+		}
+		if offset != 0 {
+			input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+		} else if r.checksumMode {
+			// S3 only sends the full object checksum when the whole object is requested
+			input.ChecksumMode = s3Types.ChecksumModeEnabled
+		}
+		r.resultsMu.Lock()
+		r.downloadedObjects++
+		r.resultsMu.Unlock()
+		objectCtx, cancelObjectCtx := context.WithCancel(spanCtx)
+		worker.cancelMu.Lock()
+		worker.cancelObject, worker.arg = cancelObjectCtx, arg
+		worker.cancelMu.Unlock()
+		getObject := func() error {
+			var err error
+			obj, err = regionalClient.GetObject(objectCtx, input)
+			return err
+		}
+		_, getObjectSpan := tracer.Start(objectCtx, "GetObject")
+		err = r.retryThrottled(getObject)
+		if err != nil && followRedirect(err) {
+			err = r.retryThrottled(getObject)
+		}
+		endSpan(getObjectSpan, err)
+		if err != nil && r.kmsDecryptCheck && isKMSError(err) {
+			fmt.Fprintf(out, "FAIL  s3://%s/%s (KMS: %s)\n", bucket, key, errorMessage(err))
+			verificationFailed = true
+			return
+		}
+		if err != nil {
+			r.printObjectError(err, bucket, key)
+			objectFailed()
+			return
+		}
+		// Some S3 compatible APIs ignore the range and send the whole object, which would be hashed on top of the bytes that were already hashed
+		if input.Range != nil {
+			contentRange := aws.ToString(obj.ContentRange)
+			if start, ok := parseContentRangeStart(contentRange); !ok || start != offset {
+				obj.Body.Close()
+				if contentRange == "" {
+					fmt.Fprintf(stderr, "Error: Requested the bytes from byte %d of s3://%s/%s, but the response has no Content-Range. The server probably ignored the range and sent the whole object.\n", offset, bucket, key)
+				} else {
+					fmt.Fprintf(stderr, "Error: Requested the bytes from byte %d of s3://%s/%s, but the response has Content-Range: %s\n", offset, bucket, key, contentRange)
+				}
+				objectFailed()
+				return
+			}
+			if r.verbose {
+				fmt.Fprintf(stderr, "Content-Range: %s\n", contentRange)
+			}
+		}
+		// The KMS key is used in the region of the bucket
+		if r.clientSideDecrypt {
+			if !isClientSideEncrypted(obj) {
+				obj.Body.Close()
+				fmt.Fprintf(stderr, "Error: The object s3://%s/%s was not encrypted with the S3 Encryption Client (it has no x-amz-key-v2 metadata).\n", bucket, key)
+				objectFailed()
+				return
+			}
+			if r.verbose {
+				fmt.Fprintf(stderr, "Decrypting s3://%s/%s with the data key in its metadata.\n", bucket, key)
+			}
+			kmsClient := kms.NewFromConfig(r.cfg, func(o *kms.Options) {
+				o.Region = regionalClient.Options().Region
+				o.Credentials = regionalClient.Options().Credentials
+			})
+			maxDecryptSize := uint64(cseMaxObjectSize)
+			if r.maxMemoryBytes != 0 {
+				maxDecryptSize = r.maxMemoryBytes
+			}
+			if err := decryptClientSide(objectCtx, kmsClient, obj, maxDecryptSize); err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to decrypt s3://%s/%s: %v\n", bucket, key, err)
+				objectFailed()
+				return
+			}
+		}
+		// Some S3 compatible APIs do not return a Content-Length, in which case progress is reported without a percentage
+		// In concat mode the total size of the stream is not known up front, and the size of normalized data is not known until the end
+		if r.concat || r.decompress || r.normalizeCRLF {
+			objLength = 0
+		} else if obj.ContentLength == nil || *obj.ContentLength < 0 {
+			if !r.quiet {
+				fmt.Fprintln(stderr, "Warning: The object size is unknown. Progress will be reported in bytes only.")
+			}
+			objLength = 0
+		} else {
+			objLength = r.position + uint64(*obj.ContentLength)
+			currentSpan.setSize(objLength)
+		}
+		if r.verbose && objVersionId == "" && obj.VersionId != nil {
+			fmt.Fprintf(stderr, "Hashing version %s (the current version).\n", aws.ToString(obj.VersionId))
+		}
+
+		// Confirm the downloads that can cost significant money before the body is read
+		// A missing or negative Content-Length means that the size is unknown, then only requester pays downloads are confirmed
+		sizeKnown := obj.ContentLength != nil && *obj.ContentLength >= 0
+		var downloadSize uint64
+		if sizeKnown {
+			downloadSize = uint64(*obj.ContentLength)
+		}
+		if !r.assumeYes && (r.requestPayer != "" || sizeKnown && downloadSize >= confirmDownloadSize) {
+			reason := "a large download"
+			if r.requestPayer != "" {
+				reason = "a requester pays download (you are charged for the data transfer)"
+			}
+			estimate := fmt.Sprintf("s3://%s/%s is %s of unknown size.", bucket, key, reason)
+			if sizeKnown {
+				estimate = fmt.Sprintf("s3://%s/%s is %s of %s. Transferring it out of AWS costs up to about $%.2f.", bucket, key, reason, formatFilesize(downloadSize, r.units), float64(downloadSize)/1e9*transferCostPerGB)
+			}
+			if !isTerminal(os.Stdin) {
+				obj.Body.Close()
+				fmt.Fprintf(stderr, "Error: %s Re-run with --assume-yes to download it.\n", estimate)
+				objectFailed()
+				return
+			}
+			if !confirm(estimate + " Continue?") {
+				obj.Body.Close()
+				skipObject(fmt.Sprintf("Skipping s3://%s/%s", bucket, key), "declined")
+				return
+			}
+		}
+
+		// There is no need to hash anything if the sizes are different
+		if r.compareLocal != "" && objLength != 0 && objLength != uint64(r.localSize) {
+			obj.Body.Close()
+			fmt.Fprintf(out, "FAILED (the local file %s is %s but the object is %s)\n", r.compareLocal, formatFilesize(uint64(r.localSize), r.units), formatFilesize(objLength, r.units))
+			verificationFailed = true
+			return
+		}
+
+		if r.kmsDecryptCheck && obj.ServerSideEncryption != s3Types.ServerSideEncryptionAwsKms && obj.ServerSideEncryption != s3Types.ServerSideEncryptionAwsKmsDsse {
+			obj.Body.Close()
+			fmt.Fprintf(out, "SKIP  s3://%s/%s (not encrypted with SSE-KMS)\n", bucket, key)
+			return
+		}
+
+		// Only download the object if its ETag can be an md5 of the object
+		if r.verifyETagOnly {
+			etag := strings.Trim(aws.ToString(obj.ETag), `"`)
+			reason := ""
+			if strings.Contains(etag, "-") {
+				reason = "multipart upload, use --reconstruct-etag instead"
+			} else if obj.ServerSideEncryption == s3Types.ServerSideEncryptionAwsKms || obj.ServerSideEncryption == s3Types.ServerSideEncryptionAwsKmsDsse || obj.SSECustomerAlgorithm != nil {
+				reason = "the ETag of an object encrypted with SSE-KMS or SSE-C is not its MD5"
+			} else if len(etag) != md5.Size*2 {
+				reason = "the ETag is not an MD5"
+			}
+			if reason != "" {
+				obj.Body.Close()
+				fmt.Fprintf(out, "NONE  s3://%s/%s (%s)\n", bucket, key, reason)
+				verificationFailed = true
+				return
+			}
+		}
+
+		// The SDK asks for the data without a transfer encoding, so the stored (e.g. gzip compressed) bytes are hashed unless --decompress is used
+		if contentEncoding := aws.ToString(obj.ContentEncoding); r.verbose && contentEncoding != "" {
+			if r.decompress {
+				fmt.Fprintf(stderr, "Content-Encoding: %s (hashing the decompressed data)\n", contentEncoding)
+			} else {
+				fmt.Fprintf(stderr, "Content-Encoding: %s (hashing the bytes as they are stored in S3)\n", contentEncoding)
+			}
+		}
+
+		// The SDK validates the body against the checksum sent by S3 once it has been read to the end
+		// Checksums of multipart uploads are composite checksums and are not validated
+		// The SDK logs a warning itself when there is no checksum it can validate
+		transferChecksum := ""
+		if r.checksumMode {
+			if m, ok := s3.GetChecksumValidationMetadata(obj.ResultMetadata); ok && len(m.AlgorithmsUsed) > 0 {
+				transferChecksum = m.AlgorithmsUsed[0]
+				if r.verbose {
+					fmt.Fprintf(stderr, "Verifying the transfer using the %s checksum sent by S3.\n", transferChecksum)
+				}
+			}
+		}
+
+		// Compute the sha256 hash
+		// The body is streamed so it is computing while the object is being downloaded
+		if r.resume == "" && !r.concat {
+			newHashes()
+		}
+		limitBandwidth := func(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+			var limiters []*rateLimiter
+			if r.bandwidthLimiter != nil {
+				limiters = append(limiters, r.bandwidthLimiter)
+			}
+			if r.bandwidthPerPart != 0 {
+				limiters = append(limiters, newRateLimiter(r.bandwidthPerPart))
+			}
+			if len(limiters) == 0 {
+				return body
+			}
+			return &rateLimitedReader{ReadCloser: body, ctx: ctx, limiters: limiters}
+		}
+		obj.Body = limitBandwidth(objectCtx, obj.Body)
+		// Ranged requests for the rest of the object are pinned to the version and ETag of the first response
+		getRange := func(ctx context.Context, rng string) (io.ReadCloser, error) {
+			rangeInput := *input
+			rangeInput.Range = aws.String(rng)
+			rangeInput.VersionId = obj.VersionId
+			rangeInput.IfMatch = obj.ETag
+			var part *s3.GetObjectOutput
+			err := r.retryThrottled(func() error {
+				var err error
+				part, err = regionalClient.GetObject(ctx, &rangeInput)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			var start int64
+			fmt.Sscanf(rng, "bytes=%d-", &start)
+			body, err := skipToOffset(part.Body, aws.ToString(part.ContentRange), start)
+			if err != nil {
+				return nil, err
+			}
+			return limitBandwidth(ctx, body), nil
+		}
+		if r.maxConcurrentParts > 1 && obj.ContentLength != nil && uint64(*obj.ContentLength) > r.partSizeBytes {
+			// Fetch the rest of the object with ranged requests in parallel
+			obj.Body = newParallelReader(objectCtx, obj.Body, int64(offset), *obj.ContentLength, int64(r.partSizeBytes), r.maxConcurrentParts, getRange)
+		} else if !r.checksumMode && obj.ContentLength != nil && *obj.ContentLength > 0 {
+			// Request the remaining bytes if the response ends early (the checksum sent by S3 can only be validated for a single response)
+			obj.Body = &resumingReader{
+				body:     obj.Body,
+				ctx:      objectCtx,
+				position: int64(offset),
+				end:      int64(offset) + *obj.ContentLength,
+				retries:  r.maxRetries,
+				getRange: getRange,
+				onRetry: func(position int64, err error) {
+					if r.quiet {
+						return
+					}
+					if err == io.EOF {
+						fmt.Fprintf(stderr, "Warning: The response ended after %s. Requesting the remaining bytes.\n", formatProgress(uint64(position), objLength, r.units))
+					} else {
+						fmt.Fprintf(stderr, "Warning: The download failed after %s: %v. Requesting the remaining bytes.\n", formatProgress(uint64(position), objLength, r.units), err)
+					}
+				},
+			}
+		}
+		copyStartTime = time.Now()
+		copyStartPosition = hashGetLen(h)
+		var body io.Reader = obj.Body
+		if r.decompress {
+			// The decompressed size is not known, so progress is reported in decompressed bytes only
+			gz, err := gzip.NewReader(obj.Body)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to decompress s3://%s/%s: %v\n", bucket, key, err)
+				objectFailed()
+				return
+			}
+			body = gz
+		}
+		if r.normalizeCRLF {
+			body = &crlfReader{r: body}
+		}
+		worker.hashed.n.Store(hashGetLen(h))
+		worker.copying.Store(true)
+		progressBar.start(worker.hashed.n.Load(), objLength)
+		hashWriters := make([]io.Writer, len(hashes))
+		for i, hh := range hashes {
+			hashWriters[i] = hh
+		}
+		if r.hmacKey != "" {
+			hashWriters = append(hashWriters, h)
+		}
+		var partHash *partHasher
+		if parts != nil {
+			sizes := parts.Sizes
+			if len(sizes) == 0 {
+				// The object was not uploaded with a multipart upload, so it consists of a single part
+				sizes = []int64{aws.ToInt64(obj.ContentLength)}
+			}
+			partHash = newPartHasher(sizes)
+			hashWriters = append(hashWriters, partHash)
+		}
+		// Hash the parts of the size that the object was uploaded with, to compare with a composite S3 checksum
+		var compositeHash *partHasher
+		if r.composite && obj.ContentLength != nil && *obj.ContentLength >= 0 {
+			compositeHash = newPartHasher(fixedPartSizes(*obj.ContentLength, int64(r.partSizeBytes)))
+			hashWriters = append(hashWriters, compositeHash)
+		}
+		var diffHash *partHasher
+		if r.diff && obj.ContentLength != nil {
+			diffHash = newPartHasher(fixedPartSizes(*obj.ContentLength, diffChunkSize))
+			hashWriters = append(hashWriters, diffHash)
+		}
+		if r.metrics != nil {
+			hashWriters = append(hashWriters, r.metrics)
+		}
+		var contentMD5 hash.Hash
+		if r.verifyContentMD5 {
+			contentMD5 = md5.New()
+			hashWriters = append(hashWriters, contentMD5)
+		}
+		var inventoryMD5 hash.Hash
+		if r.inventoryObjects != nil {
+			inventoryMD5 = md5.New()
+			hashWriters = append(hashWriters, inventoryMD5)
+		}
+		hashWriters = append(hashWriters, &worker.hashed)
+		var hashWriter io.Writer = &lockedWriter{mu: &worker.hashMu, w: io.MultiWriter(hashWriters...)}
+		if r.checkpointBytes != 0 {
+			hashWriter = &checkpointWriter{
+				w:          hashWriter,
+				position:   hashGetLen(h),
+				interval:   r.checkpointBytes,
+				checkpoint: printResumeStatus,
+			}
+		}
+		if r.metrics != nil {
+			r.metrics.inFlight.Add(1)
+		}
+		_, hashSpan := tracer.Start(objectCtx, "hash")
+		_, err = io.Copy(hashWriter, body)
+		hashSpan.SetAttributes(attribute.Int64("s3sha256sum.bytes", int64(hashGetLen(h)-copyStartPosition)))
+		endSpan(hashSpan, err)
+		worker.copying.Store(false)
+		progressBar.stop()
+		if r.metrics != nil {
+			r.metrics.inFlight.Add(-1)
+		}
+		obj.Body.Close()
+		cancelObjectCtx()
+		if err != nil {
+			position := hashGetLen(h)
+			if errors.Is(err, context.Canceled) && r.ctx.Err() == nil {
+				// The object was skipped with --interrupt-skips, which is not a failure
+				skipObject(fmt.Sprintf("Skipped s3://%s/%s after %s.", bucket, key, formatProgress(position, objLength, r.units)), "skipped")
+				return
+			} else if errors.Is(err, context.Canceled) {
+				fmt.Fprintf(stderr, "Aborted after %s.\n", formatProgress(position, objLength, r.units))
+			} else if r.checksumOnly && isChecksumMismatchError(err) {
+				fmt.Fprintf(out, "FAIL  s3://%s/%s\n", bucket, key)
+				verificationFailed = true
+				return
+			} else if transferChecksum != "" && isChecksumMismatchError(err) {
+				// The data that was hashed is corrupt, so the hash state is not worth resuming from
+				fmt.Fprintf(stderr, "Error: The downloaded data did not match the %s checksum sent by S3. The object may have been corrupted in transit.\n", transferChecksum)
+				if r.verbose || r.debug {
+					fmt.Fprintln(stderr, err)
+				}
+				objectFailed()
+				return
+			} else {
+				fmt.Fprintf(stderr, "Error after %s: %v\n", formatProgress(position, objLength, r.units), err)
+				if isExpiredCredentialsError(err) {
+					fmt.Fprintln(stderr, "The credentials expired during the download. Refresh them (e.g. run aws sso login) and resume with the command below.")
+				}
+			}
+			if r.resumable {
+				fmt.Fprintln(stderr)
+				printResumeHelp()
+			}
+			if r.ctx.Err() != nil {
+				os.Exit(1)
+			}
+			objectFailed()
+			return
+		}
+		if r.decompress || r.normalizeCRLF {
+			objLength = hashGetLen(h)
+		} else if sizeMismatch(hashGetLen(h)-copyStartPosition, obj.ContentLength) {
+			fmt.Fprintf(stderr, "Error: Received %s but the object size was reported as %s.\n", formatFilesize(hashGetLen(h)-copyStartPosition, r.units), formatFilesize(uint64(*obj.ContentLength), r.units))
+			objectFailed()
+			return
+		}
+		if r.verbose && !r.concat && hashGetLen(h) == 0 {
+			fmt.Fprintln(stderr, "The object is empty. Its sha256 sum is the well-known sum of empty data (e3b0c442...).")
+		}
+		if r.concat && i != len(r.args)-1 {
+			return
+		}
+		if r.paranoidInterval != 0 || r.checkpointBytes != 0 || r.verbose {
+			fmt.Fprintln(stderr)
+		}
+
+		// Compare against the checksum stored by S3 without printing our own sum
+		// Objects uploaded with multipart uploads have a checksum of the part checksums (with a -<parts> suffix) which can not be compared
+		if r.checksumOnly {
+			storedSum, err := base64.StdEncoding.DecodeString(aws.ToString(obj.ChecksumSHA256))
+			if err != nil || len(storedSum) != sha256.Size {
+				fmt.Fprintf(out, "NONE  s3://%s/%s (no stored checksum to compare)\n", bucket, key)
+				verificationFailed = true
+			} else if bytes.Equal(h.Sum(nil), storedSum) {
+				fmt.Fprintf(out, "PASS  s3://%s/%s\n", bucket, key)
+			} else {
+				fmt.Fprintf(out, "FAIL  s3://%s/%s\n", bucket, key)
+				verificationFailed = true
+			}
+			return
+		}
+		if r.verifyETagOnly {
+			if hex.EncodeToString(h.Sum(nil)) == strings.Trim(aws.ToString(obj.ETag), `"`) {
+				fmt.Fprintf(out, "PASS  s3://%s/%s\n", bucket, key)
+			} else {
+				fmt.Fprintf(out, "FAIL  s3://%s/%s\n", bucket, key)
+				verificationFailed = true
+			}
+			return
+		}
+		if r.kmsDecryptCheck {
+			fmt.Fprintf(out, "PASS  s3://%s/%s (decrypted with %s)\n", bucket, key, aws.ToString(obj.SSEKMSKeyId))
+			return
+		}
+
+		// The SHA256 checksum stored by S3 is only sent with --checksum-mode, otherwise only a checksum that the response already has is compared
+		// A ranged response (e.g. when resuming) has no checksums, and --fail-on-checksum-algorithm-mismatch and --verify-parallel-hashes need them, so they are then looked up with a HeadObject request
+		// The algorithms of the other checksums that S3 has for the object are kept in nativeAlgorithms
+		var nativeChecksum *string
+		var nativeAlgorithms []string
+		getNativeChecksum := func() string {
+			if nativeChecksum != nil {
+				return *nativeChecksum
+			}
+			checksum := aws.ToString(obj.ChecksumSHA256)
+			nativeAlgorithms = checksumAlgorithms(obj.ChecksumCRC32, obj.ChecksumCRC32C, obj.ChecksumSHA1)
+			requested := r.checksumMode && obj.ContentRange == nil
+			if !requested && (r.checksumMode || r.failOnAlgorithmMismatch || r.verifyParallelHashes) && checksum == "" && len(nativeAlgorithms) == 0 {
+				nativeHeadInput := *headObjectInput
+				nativeHeadInput.VersionId = obj.VersionId
+				nativeHeadInput.ChecksumMode = s3Types.ChecksumModeEnabled
+				nativeHead, err := regionalClient.HeadObject(r.ctx, &nativeHeadInput)
+				if err != nil {
+					if !r.quiet {
+						fmt.Fprintf(stderr, "Warning: Was not able to get the S3 checksum of the object: %v\n", err)
+					}
+				} else {
+					checksum = aws.ToString(nativeHead.ChecksumSHA256)
+					nativeAlgorithms = checksumAlgorithms(nativeHead.ChecksumCRC32, nativeHead.ChecksumCRC32C, nativeHead.ChecksumSHA1)
+				}
+			}
+			nativeChecksum = &checksum
+			return checksum
+		}
+		// The composite checksum has the format <base64>-<parts> like the checksum stored by S3
+		getCompositeChecksum := func() string {
+			compositeHash.finish()
+			return fmt.Sprintf("%s-%d", compositeHash.CompositeChecksum(), compositeHash.count)
+		}
+
+		// With multiple algorithms, each sum is printed on its own line labeled with the algorithm
+		sums := make(map[string]string)
+		for i, hh := range hashes {
+			sums[r.algorithms[i]] = hex.EncodeToString(hh.Sum(nil))
+		}
+		// Compare with the sum in the --check file instead of printing the sum and comparing with the stored sums
+		if r.checkSums != nil {
+			if sums[r.algorithms[0]] == r.checkSums[i] {
+				fmt.Fprintln(out, formatCheckLine(arg, "OK"))
+				r.resultsMu.Lock()
+				r.checkedOK++
+				r.resultsMu.Unlock()
+			} else {
+				fmt.Fprintln(out, formatCheckLine(arg, "FAILED"))
+				verificationFailed = true
+			}
+			return
+		}
+
+		// The files in --output-dir only contain one algorithm, so the lines are not labeled
+		// The BSD format already names the algorithm
+		printSumLine := func(algorithm, line string) {
+			labeled := line
+			if !r.tag {
+				labeled = r.label(algorithm) + line
+			}
+			fmt.Fprintln(out, labeled)
+			if r.syslogOutput != nil {
+				fmt.Fprintln(r.syslogOutput, labeled)
+			}
+			if r.appendFile != nil && !r.outputOnMismatchOnly {
+				err := appendLine(r.appendFile, labeled)
+				if err != nil {
+					fmt.Fprintf(stderr, "Error: Unable to write to --append-to file: %v\n", err)
+					os.Exit(1)
+				}
+			}
+			if r.outputDir != "" {
+				f, err := r.outputFile(bucket, algorithm)
+				if err == nil {
+					err = appendLine(f, line)
+				}
+				if err != nil {
+					fmt.Fprintf(stderr, "Error: Unable to write to the --output-dir file for %s: %v\n", bucket, err)
+					os.Exit(1)
+				}
+			}
+		}
+
+		// Print the combined sum, there is nothing to compare it against
+		if r.concat {
+			for _, algorithm := range r.algorithms {
+				printSumLine(algorithm, fmt.Sprintf("%s  %s", printedSum(sums[algorithm]), strings.Join(r.args, " ")))
+			}
+			return
+		}
+
+		// Print the sum
+		printedKey, escaped := escapeKey(key)
+		var lastModified string
+		if obj.LastModified != nil {
+			lastModified = obj.LastModified.Format(time.RFC3339)
+		}
+		for _, algorithm := range r.algorithms {
+			if r.manifest != "" {
+				printSumLine(algorithm, formatManifestRow(bucket, key, aws.ToString(obj.VersionId), printedSum(sums[algorithm])))
+				continue
+			}
+			var line strings.Builder
+			if escaped && (r.outputFormat == defaultOutputFormat || r.outputFormat == tagOutputFormat) {
+				line.WriteString(`\`)
+			}
+			err = r.outputTemplate.Execute(&line, outputLine{
+				Sum:          printedSum(sums[algorithm]),
+				Algorithm:    strings.ToUpper(algorithm),
+				Bucket:       bucket,
+				Key:          printedKey,
+				Size:         objLength,
+				ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+				VersionId:    aws.ToString(obj.VersionId),
+				LastModified: lastModified,
+				Region:       regionalClient.Options().Region,
+			})
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to render the --format template: %v\n", err)
+				os.Exit(1)
+			}
+			printSumLine(algorithm, line.String())
+		}
+		if reportObj != nil {
+			reportObj.Size = objLength
+			reportObj.Sum = sums[r.algorithms[0]]
+			reportObj.Sums = sums
+			reportObj.VersionId = aws.ToString(obj.VersionId)
+			reportObj.Region = regionalClient.Options().Region
+			reportObj.LastModified = lastModified
+			reportObj.Result = reportNotCompared
+		}
+		r.resultsMu.Lock()
+		if r.lastRun != nil {
+			r.lastRun[stateURI] = runStateEntry{
+				ETag:     strings.Trim(aws.ToString(obj.ETag), `"`),
+				HashedAt: time.Now(),
+				Sum:      sums["sha256"],
+			}
+			if err := r.lastRun.save(r.sinceLastRun); err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to write the --since-last-run state file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if r.detectDuplicates && !slices.Contains(r.duplicates[sums["sha256"]], stateURI) {
+			r.duplicates[sums["sha256"]] = append(r.duplicates[sums["sha256"]], stateURI)
+		}
+		if r.merkle {
+			r.merkleLeaves = append(r.merkleLeaves, merkleLeaf{URI: stateURI, Sum: sums["sha256"]})
+		}
+		r.resultsMu.Unlock()
+		if r.metrics != nil {
+			r.metrics.objectsHashed.Add(1)
+		}
+		if r.recorder != nil {
+			if err := r.recorder.record(r.ctx, bucket, key, aws.ToString(obj.VersionId), sums["sha256"], objLength, time.Now()); err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to write to the DynamoDB table %s: %v\n", r.recordToDynamoDB, err)
+				os.Exit(1)
+			}
+		}
+		// The object is downloaded again without a range, and the current version is downloaded unless a version was specified
+		if r.watchInterval > 0 {
+			watchInput := *input
+			watchInput.Range = nil
+			r.resultsMu.Lock()
+			r.watched = append(r.watched, watchedObject{URI: stateURI, Client: regionalClient, Input: &watchInput, Sum: sums["sha256"]})
+			r.resultsMu.Unlock()
+		}
+		if r.postHashCommand != "" {
+			// The version that was hashed, also when the current version was requested
+			runHashHook("--post-hash-command", r.postHashCommand, map[string]string{
+				"S3SHA256_SUM":        sums[r.algorithms[0]],
+				"S3SHA256_ALGORITHM":  r.algorithms[0],
+				"S3SHA256_SIZE":       strconv.FormatUint(objLength, 10),
+				"S3SHA256_VERSION_ID": aws.ToString(obj.VersionId),
+			})
+		}
+		// Printed to stderr to keep stdout compatible with sha256sum --check
+		if r.verbose && lastModified != "" {
+			fmt.Fprintf(stderr, "Last modified: %s\n", lastModified)
+		}
+		if r.manifest != "" {
+			return
+		}
+		printSeparator()
+
+		if partHash != nil {
+			if r.printParts {
+				printPartSums(out, partHash, parts)
+				printSeparator()
+			}
+			printReconstructedETag(out, partHash, parts, obj)
+			printSeparator()
+		}
+		if contentMD5 != nil {
+			if !printContentMD5(out, contentMD5.Sum(nil), obj) {
+				verificationFailed = true
+			}
+			printSeparator()
+		}
+		if r.compareLocal != "" {
+			if r.cache != nil {
+				r.cache.store(stateURI, strings.Trim(aws.ToString(obj.ETag), `"`), sums)
+				r.saveCache()
+			}
+			matched, err := compareLocalSums(sums)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to hash the --compare-local file: %v\n", err)
+				objectFailed()
+				return
+			}
+			// Find the first chunk that differs, and then the first byte in it
+			if !matched && diffHash != nil {
+				diffHash.finish()
+				localHash, err := hashLocalParts(r.compareLocal, fixedPartSizes(r.localSize, diffChunkSize))
+				if err != nil {
+					fmt.Fprintf(stderr, "Error: Unable to hash the --compare-local file: %v\n", err)
+					objectFailed()
+					return
+				}
+				chunk := firstDifferentPart(diffHash, localHash)
+				if chunk == -1 {
+					fmt.Fprintln(out, "Diff: The chunks have the same sums, the difference could not be located.")
+				} else {
+					start := int64(chunk) * diffChunkSize
+					end := min(start+diffChunkSize, r.localSize)
+					offset, err := firstDifferentByte(r.ctx, getRange, r.compareLocal, start, end)
+					if err != nil {
+						fmt.Fprintln(stderr, "Was not able to download the chunk that differs (needed for --diff).")
+						r.printObjectError(err, bucket, key)
+						objectFailed()
+						return
+					}
+					fmt.Fprintf(out, "Diff: The first difference is at byte %d (in the chunk of bytes %d-%d)\n", offset, start, end-1)
+				}
+			}
+			printSeparator()
+		}
+		if r.verifyParallelHashes && compositeHash != nil {
+			checksum := getNativeChecksum()
+			fullSum, _ := hex.DecodeString(sums["sha256"])
+			describe := func(computed string) string {
+				if checksum == "" {
+					return "the object has no S3 checksum to compare with"
+				} else if computed == checksum {
+					return "matches the S3 checksum"
+				}
+				return "does not match the S3 checksum"
+			}
+			full := base64.StdEncoding.EncodeToString(fullSum)
+			fmt.Fprintf(out, "Full object: %s (%s)\n", full, describe(full))
+			fmt.Fprintf(out, "Composite:   %s (%s)\n", getCompositeChecksum(), describe(getCompositeChecksum()))
+			if checksum != "" && checksum != full && checksum != getCompositeChecksum() {
+				fmt.Fprintf(out, "S3 checksum: %s\n", checksum)
+			}
+			printSeparator()
+		}
+
+		if r.fullFingerprintFlag {
+			var tags map[string]string
+			if aws.ToInt32(obj.TagCount) > 0 {
+				tags, err = getObjectTags(r.ctx, regionalClient, getObjectTaggingInput)
+				if err != nil {
+					fmt.Fprintln(stderr, "Was not able to get object tags (needed for --full-fingerprint).")
+					fmt.Fprintln(stderr, err)
+					objectFailed()
+					return
+				}
+			}
+			fmt.Fprintf(out, "Fingerprint: %x (content, %d metadata entries and %d tags)\n", fullFingerprint(sums["sha256"], obj.Metadata, tags), len(obj.Metadata), len(tags))
+			printSeparator()
+		}
+
+		// S3 only returns the Object Lock fields to callers that are allowed to read them
+		if r.verifyObjectLock {
+			locked, status := objectLockStatus(obj.ObjectLockMode, obj.ObjectLockRetainUntilDate, obj.ObjectLockLegalHoldStatus, time.Now())
+			if !locked && r.requireLock {
+				fmt.Fprintf(out, "Object Lock: FAILED (%s)\n", status)
+				verificationFailed = true
+			} else {
+				fmt.Fprintf(out, "Object Lock: %s\n", status)
+			}
+			printSeparator()
+		}
+
+		// The object was listed in the --inventory report, so it is in the map unless the same object was also given as an argument
+		if o, ok := r.inventoryObjects[stateURI]; ok {
+			if !printInventoryComparison(out, o, objLength, inventoryMD5.Sum(nil), obj) {
+				verificationFailed = true
+			}
+			printSeparator()
+		}
+
+		// The stored sums are sums of the whole object
+		if r.hmacKey != "" {
+			return
+		}
+		if r.fromByteOffset != 0 {
+			fmt.Fprintf(stderr, "Note: This is the sum of the bytes from byte %d onward, so it is not compared with the stored sum.\n", r.fromByteOffset)
+			return
+		}
+
+		var tags map[string]string
+		if aws.ToInt32(obj.TagCount) > 0 {
+			tags, err = getObjectTags(r.ctx, regionalClient, getObjectTaggingInput)
+			if err != nil {
+				fmt.Fprintln(stderr, "Was not able to get object tags (looking for the sums to compare against).")
+				fmt.Fprintln(stderr, err)
+				objectFailed()
+				return
+			}
+		}
+
+		// Compare with every location a sum can be stored in, and report each of them
+		// Each algorithm is compared with the metadata and tag named after it (e.g. md5sum), the other locations only have sha256 sums
+		// The sidecar object is only checked with --sidecar
+		storedSums := make(map[string][]storedSum)
+		for _, algorithm := range r.algorithms {
+			var stored []storedSum
+			if algorithm == "sha256" && r.sidecar {
+				sidecarKey := key + ".sha256"
+				sidecarSum, err := getSidecarSum(r.ctx, regionalClient, &s3.GetObjectInput{
+					Bucket:              aws.String(bucket),
+					Key:                 aws.String(sidecarKey),
+					ExpectedBucketOwner: input.ExpectedBucketOwner,
+					RequestPayer:        input.RequestPayer,
+				})
+				if err != nil {
+					fmt.Fprintf(stderr, "Was not able to read the sidecar object s3://%s/%s.\n", bucket, sidecarKey)
+					fmt.Fprintln(stderr, err)
+					storedSums = nil
+					break
+				}
+				stored = append(stored, storedSum{Source: "Sidecar", Sum: sidecarSum})
+			}
+			if algorithm == "sha256" && r.checksumHeader != "" {
+				stored = append(stored, headerStoredSum(getResponseHeader(obj.ResultMetadata, r.checksumHeader)))
+			}
+			if algorithm == "sha256" && r.dynamoDBTable != "" {
+				dynamoDBSum, err := getDynamoDBSum(r.ctx, r.dynamoDBClient, r.dynamoDBTable, bucket, key)
+				if err != nil {
+					fmt.Fprintf(stderr, "Was not able to get the sum from the DynamoDB table %s.\n", r.dynamoDBTable)
+					fmt.Fprintln(stderr, err)
+					storedSums = nil
+					break
+				}
+				stored = append(stored, storedSum{Source: "DynamoDB", Sum: dynamoDBSum})
+			}
+			stored = append(stored, storedSum{Source: "Metadata", Sum: obj.Metadata[algorithm+"sum"]})
+			stored = append(stored, storedSum{Source: "Tag", Sum: tags[algorithm+"sum"]})
+			// The checksum computed by S3 is of the data stored in S3 (the encrypted data with --client-side-decrypt)
+			if algorithm == "sha256" && !r.decompress && !r.normalizeCRLF && !r.clientSideDecrypt {
+				checksum := getNativeChecksum()
+				native := nativeStoredSum(checksum, nativeAlgorithms)
+				if compositeHash != nil && strings.Contains(checksum, "-") {
+					native = storedSum{
+						Source:   native.Source,
+						Sum:      checksum,
+						Computed: getCompositeChecksum(),
+					}
+				}
+				stored = append(stored, native)
+				if r.failOnAlgorithmMismatch && checksum == "" && len(nativeAlgorithms) > 0 {
+					verificationFailed = true
+				}
+			}
+			storedSums[algorithm] = stored
+		}
+		if storedSums == nil {
+			objectFailed()
+			return
+		}
+		present := false
+		for _, algorithm := range r.algorithms {
+			stored := storedSums[algorithm]
+			if printStoredSums(out, r.label(algorithm), algorithm, sums[algorithm], stored) {
+				present = true
+			}
+			if !r.quiet && !storedSumsAgree(stored) {
+				fmt.Fprintf(stderr, "Warning: The %s sums stored for s3://%s/%s do not agree with each other.\n", algorithm, bucket, key)
+			}
+			// Only the sha256 sum is compared by default, so a mismatch of another algorithm that was asked for with --algorithm fails the run
+			if algorithm != "sha256" && len(mismatchedStoredSums(sums[algorithm], stored)) > 0 {
+				verificationFailed = true
+			}
+		}
+		if !present && r.strictMetadata {
+			verificationFailed = true
+		}
+
+		// Download the object again when it does not match a stored sum, the first download counts as attempt 1
+		// The downloads are pinned to the version and ETag of the first download, so a changed object is not mistaken for a match
+		retryMatched := false
+		if sum, stored := sums["sha256"], storedSums["sha256"]; r.verifyRetries > 0 && storedSumsFailed(sum, stored) {
+			retryInput := *input
+			retryInput.Range = nil
+			retryInput.VersionId = obj.VersionId
+			retryInput.IfMatch = obj.ETag
+			for attempt := 2; attempt <= r.verifyRetries+1; attempt++ {
+				if r.verbose {
+					fmt.Fprintf(stderr, "Downloading s3://%s/%s again (attempt %d of %d).\n", bucket, key, attempt, r.verifyRetries+1)
+				}
+				var retrySum string
+				err := r.retryThrottled(func() error {
+					var err error
+					retrySum, err = downloadSum(r.ctx, regionalClient, &retryInput)
+					return err
+				})
+				if err != nil {
+					r.printObjectError(err, bucket, key)
+					break
+				}
+				if storedSumsFailed(retrySum, stored) {
+					fmt.Fprintf(out, "Attempt %d:  %s (FAILED)\n", attempt, printedSum(retrySum))
+					continue
+				}
+				fmt.Fprintf(out, "Attempt %d:  %s (OK, the earlier downloads were probably corrupted in transit)\n", attempt, printedSum(retrySum))
+				retryMatched = true
+				if reportObj != nil && r.algorithms[0] == "sha256" {
+					reportObj.compare(retrySum, stored)
+				}
+				break
+			}
+			if !retryMatched {
+				verificationFailed = true
+			}
+		}
+
+		// The objects that still do not match are the only lines in the --append-to file
+		// The sha256 sum of an object that matched when it was downloaded again is not counted as a mismatch
+		mismatched := false
+		for _, algorithm := range r.algorithms {
+			if algorithm == "sha256" && retryMatched {
+				continue
+			}
+			for _, s := range mismatchedStoredSums(sums[algorithm], storedSums[algorithm]) {
+				mismatched = true
+				if r.outputOnMismatchOnly {
+					err := appendLine(r.appendFile, formatMismatchLine(stateURI, sums[algorithm], s))
+					if err != nil {
+						fmt.Fprintf(stderr, "Error: Unable to write to --append-to file: %v\n", err)
+						os.Exit(1)
+					}
+				}
+			}
+		}
+
+		// A stored sha256 sum that does not match does not change the exit status, but the object is still recorded as failed
+		failed := verificationFailed || mismatched
+		if failed {
+			currentSpan.end("FAILED")
+			// Scripts that use --output json can rely on the exit status instead of checking the match of each record
+			if jsonOutput != nil {
+				verificationFailed = true
+			}
+		}
+		if (r.writeTag || r.writeMetadata) && failed && !r.force {
+			fmt.Fprintf(stderr, "Error: Not storing the sums of s3://%s/%s since they do not match a stored sum. Use --force to overwrite it.\n", bucket, key)
+			verificationFailed = true
+		} else if r.writeTag || r.writeMetadata {
+			if names := changedSums(tags, sums); r.writeTag && len(names) > 0 {
+				tagInput := *getObjectTaggingInput
+				tagInput.VersionId = obj.VersionId
+				err := writeSumTags(r.ctx, regionalClient, &tagInput, sums)
+				if err != nil {
+					fmt.Fprintf(stderr, "Was not able to write %s.\n", describeSums(names, "tag", "tags"))
+					fmt.Fprintln(stderr, err)
+					objectFailed()
+					return
+				}
+				fmt.Fprintf(out, "Wrote %s.\n", describeSums(names, "tag", "tags"))
+			}
+			if names := changedSums(obj.Metadata, sums); r.writeMetadata && len(names) > 0 {
+				err := writeSumMetadata(r.ctx, regionalClient, input, obj, objLength, sums)
+				if err != nil {
+					fmt.Fprintf(stderr, "Was not able to write %s.\n", describeSums(names, "metadata", "metadata"))
+					fmt.Fprintln(stderr, err)
+					objectFailed()
+					return
+				}
+				fmt.Fprintf(out, "Wrote %s.\n", describeSums(names, "metadata", "metadata"))
+			}
+		}
+		if reportObj != nil {
+			if !retryMatched || r.algorithms[0] != "sha256" {
+				reportObj.compare(sums[r.algorithms[0]], storedSums[r.algorithms[0]])
+			}
+			if failed {
+				reportObj.Result = reportFailed
+			} else if present {
+				reportObj.Result = reportOK
+			} else {
+				reportObj.Result = reportNoSum
+			}
+		}
+		if r.runJournal != nil {
+			if err := r.runJournal.record(stateURI, failed, sums, r.algorithms); err != nil {
+				fmt.Fprintf(stderr, "Error: Unable to write to the --journal file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// CopyObject can only copy objects of up to 5 GiB in a single request
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// Returns the quoted names of the sums (e.g. 'sha256sum') that are not already stored in the metadata or tags, sorted by name
+func changedSums(stored map[string]string, sums map[string]string) []string {
+	var names []string
+	for algorithm, sum := range sums {
+		if !strings.EqualFold(stored[algorithm+"sum"], sum) {
+			names = append(names, fmt.Sprintf("'%ssum'", algorithm))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describes the names returned by changedSums, e.g. "the 'md5sum' and 'sha256sum' tags"
+func describeSums(names []string, singular, plural string) string {
+	if len(names) > 1 {
+		return fmt.Sprintf("the %s %s", strings.Join(names, " and "), plural)
+	}
+	return fmt.Sprintf("the %s %s", strings.Join(names, " and "), singular)
+}
+
+// Sets a tag for each sum (e.g. 'sha256sum'), the other tags of the object are kept since PutObjectTagging replaces the whole tag set
+func writeSumTags(ctx context.Context, client *s3.Client, input *s3.GetObjectTaggingInput, sums map[string]string) error {
+	tags, err := getObjectTags(ctx, client, input)
+	if err != nil {
+		return err
+	}
+	for algorithm, sum := range sums {
+		tags[algorithm+"sum"] = sum
+	}
+	var tagSet []s3Types.Tag
+	for k, v := range tags {
+		tagSet = append(tagSet, s3Types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err = client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:              input.Bucket,
+		Key:                 input.Key,
+		VersionId:           input.VersionId,
+		ExpectedBucketOwner: input.ExpectedBucketOwner,
+		RequestPayer:        input.RequestPayer,
+		Tagging:             &s3Types.Tagging{TagSet: tagSet},
+	})
+	return err
+}
+
+// Sets the metadata for each sum (e.g. 'sha256sum') by copying the object onto itself, since the metadata of an object can not be changed in place
+// The copy is made from the version and ETag that was hashed, and keeps the headers, storage class, encryption and tags of the object
+// The ACL is not copied, and in a versioned bucket the copy is a new version of the object
+func writeSumMetadata(ctx context.Context, client *s3.Client, input *s3.GetObjectInput, obj *s3.GetObjectOutput, size uint64, sums map[string]string) error {
+	if size > maxCopyObjectSize {
+		return fmt.Errorf("the object is larger than 5 GiB, which can not be copied in a single request (use --write-tag instead)")
+	}
+	metadata := maps.Clone(obj.Metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	for algorithm, sum := range sums {
+		metadata[algorithm+"sum"] = sum
+	}
+	copySource := url.PathEscape(aws.ToString(input.Bucket) + "/" + aws.ToString(input.Key))
+	if obj.VersionId != nil {
+		copySource += "?versionId=" + url.QueryEscape(*obj.VersionId)
+	}
+	copyInput := &s3.CopyObjectInput{
+		Bucket:                         input.Bucket,
+		Key:                            input.Key,
+		CopySource:                     aws.String(copySource),
+		CopySourceIfMatch:              obj.ETag,
+		ExpectedBucketOwner:            input.ExpectedBucketOwner,
+		ExpectedSourceBucketOwner:      input.ExpectedBucketOwner,
+		RequestPayer:                   input.RequestPayer,
+		MetadataDirective:              s3Types.MetadataDirectiveReplace,
+		Metadata:                       metadata,
+		CacheControl:                   obj.CacheControl,
+		ContentDisposition:             obj.ContentDisposition,
+		ContentEncoding:                obj.ContentEncoding,
+		ContentLanguage:                obj.ContentLanguage,
+		ContentType:                    obj.ContentType,
+		Expires:                        obj.Expires,
+		WebsiteRedirectLocation:        obj.WebsiteRedirectLocation,
+		StorageClass:                   obj.StorageClass,
+		CopySourceSSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		CopySourceSSECustomerKey:       input.SSECustomerKey,
+		CopySourceSSECustomerKeyMD5:    input.SSECustomerKeyMD5,
+		SSECustomerAlgorithm:           input.SSECustomerAlgorithm,
+		SSECustomerKey:                 input.SSECustomerKey,
+		SSECustomerKeyMD5:              input.SSECustomerKeyMD5,
+	}
+	if obj.ServerSideEncryption == s3Types.ServerSideEncryptionAwsKms || obj.ServerSideEncryption == s3Types.ServerSideEncryptionAwsKmsDsse {
+		copyInput.ServerSideEncryption = obj.ServerSideEncryption
+		copyInput.SSEKMSKeyId = obj.SSEKMSKeyId
+		copyInput.BucketKeyEnabled = obj.BucketKeyEnabled
+	}
+	_, err := client.CopyObject(ctx, copyInput)
+	return err
+}